@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"gh-proxy/internal/fakegithub"
+)
+
+// fake-github serves a local stand-in for the GitHub API and OAuth endpoints.
+// Run the proxy with GITHUB_API_URL and GITHUB_WEB_URL set to its address.
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	limit := flag.Int("limit", 5000, "core and GraphQL requests per token per window")
+	window := flag.Duration("window", time.Hour, "rate limit window")
+	flag.Parse()
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           fakegithub.New(*limit, *window),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("fake GitHub listening on %s (%d requests per token every %s)", *addr, *limit, *window)
+	log.Fatal(httpServer.ListenAndServe())
+}
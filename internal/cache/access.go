@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// accessLog batches cache-hit bookkeeping in memory so a hit never costs a
+// write; flush folds it into last_accessed_at / hit_count for eviction.
+type accessLog struct {
+	mu      sync.Mutex
+	pending map[int64]*accessStat
+}
+
+type accessStat struct {
+	last time.Time
+	hits int64
+}
+
+// upper bound on distinct rows tracked between flushes
+const maxPendingAccess = 100000
+
+func newAccessLog() *accessLog { return &accessLog{pending: map[int64]*accessStat{}} }
+
+func (a *accessLog) record(id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st, ok := a.pending[id]
+	if !ok {
+		if len(a.pending) >= maxPendingAccess { return }
+		st = &accessStat{}
+		a.pending[id] = st
+	}
+	st.last = time.Now()
+	st.hits++
+}
+
+func (a *accessLog) drain() map[int64]*accessStat {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.pending
+	a.pending = map[int64]*accessStat{}
+	return p
+}
+
+// flushAccess writes batched hit counts and access times in one UPDATE.
+func (pg *PostgresBackend) flushAccess(ctx context.Context) error {
+	p := pg.access.drain()
+	if len(p) == 0 { return nil }
+	ids := make([]int64, 0, len(p))
+	lasts := make([]time.Time, 0, len(p))
+	hits := make([]int64, 0, len(p))
+	for id, st := range p {
+		ids = append(ids, id)
+		lasts = append(lasts, st.last)
+		hits = append(hits, st.hits)
+	}
+	_, err := pg.pool.Exec(ctx, `UPDATE cached_responses c SET last_accessed_at=GREATEST(c.last_accessed_at, v.last), hit_count=c.hit_count+v.hits FROM unnest($1::bigint[], $2::timestamptz[], $3::bigint[]) AS v(id, last, hits) WHERE c.id=v.id`, ids, lasts, hits)
+	return err
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Info describes a stored entry for the admin cache inspector.
+type Info struct {
+	ID        int64               `json:"id"`
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	Status    int                 `json:"status"`
+	Encoding  string              `json:"encoding"`
+	Size      int64               `json:"size"` // stored body bytes
+	BodyHash  string              `json:"body_hash,omitempty"` // shared blob, empty for legacy inline bodies
+	CreatedAt time.Time           `json:"created_at"`
+	ExpiresAt *time.Time          `json:"expires_at"`
+	Headers   map[string][]string `json:"headers,omitempty"`
+}
+
+// likePrefix escapes LIKE wildcards so prefix matching is literal.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+// Search lists the newest entries whose URL starts with prefix.
+func (pg *PostgresBackend) Search(ctx context.Context, prefix string, limit int) ([]Info, error) {
+	rows, err := pg.pool.Query(ctx, `SELECT r.id, r.method, r.url, r.status, COALESCE(b.encoding, r.resp_encoding), COALESCE(b.size, octet_length(r.resp_body), 0), COALESCE(r.body_hash, ''), r.created_at, r.expires_at FROM cached_responses r LEFT JOIN cache_blobs b ON b.hash=r.body_hash WHERE r.url LIKE $1 ORDER BY r.id DESC LIMIT $2`, likePrefix(prefix), limit)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	out := []Info{}
+	for rows.Next() {
+		var in Info
+		if err := rows.Scan(&in.ID, &in.Method, &in.URL, &in.Status, &in.Encoding, &in.Size, &in.BodyHash, &in.CreatedAt, &in.ExpiresAt); err != nil { return nil, err }
+		out = append(out, in)
+	}
+	return out, rows.Err()
+}
+
+// Inspect returns one entry including its stored response headers, or nil if it doesn't exist.
+func (pg *PostgresBackend) Inspect(ctx context.Context, id int64) (*Info, error) {
+	var in Info
+	var hdr []byte
+	err := pg.pool.QueryRow(ctx, `SELECT r.id, r.method, r.url, r.status, COALESCE(b.encoding, r.resp_encoding), COALESCE(b.size, octet_length(r.resp_body), 0), COALESCE(r.body_hash, ''), r.created_at, r.expires_at, r.resp_headers FROM cached_responses r LEFT JOIN cache_blobs b ON b.hash=r.body_hash WHERE r.id=$1`, id).Scan(&in.ID, &in.Method, &in.URL, &in.Status, &in.Encoding, &in.Size, &in.BodyHash, &in.CreatedAt, &in.ExpiresAt, &hdr)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) { return nil, nil }
+		return nil, err
+	}
+	_ = json.Unmarshal(hdr, &in.Headers)
+	return &in, nil
+}
+
+// Search lists the newest entries whose URL starts with prefix.
+func (c *Cache) Search(ctx context.Context, prefix string, limit int) ([]Info, error) {
+	sr, ok := c.backend.(Searcher)
+	if !ok { return nil, ErrUnsupported }
+	return sr.Search(ctx, CanonicalPrefix(prefix), limit)
+}
+
+// Inspect returns one entry including its stored response headers, or nil if it doesn't exist.
+func (c *Cache) Inspect(ctx context.Context, id int64) (*Info, error) {
+	sr, ok := c.backend.(Searcher)
+	if !ok { return nil, ErrUnsupported }
+	return sr.Inspect(ctx, id)
+}
+
+// PurgeURL evicts every entry (all methods and variants) for one URL.
+func (c *Cache) PurgeURL(ctx context.Context, url string) (int64, error) {
+	url = CanonicalURL(url)
+	n, err := c.backend.Delete(ctx, Match{URL: url})
+	if err != nil { return 0, err }
+	c.mem.removeIf(func(k string) bool { _, u, _ := splitRowKey(k); return u == url })
+	return n, nil
+}
+
+// PurgePrefix evicts every entry whose URL starts with prefix.
+func (c *Cache) PurgePrefix(ctx context.Context, prefix string) (int64, error) {
+	prefix = CanonicalPrefix(prefix)
+	n, err := c.backend.Delete(ctx, Match{Prefix: prefix})
+	if err != nil { return 0, err }
+	c.mem.removeIf(func(k string) bool { _, u, _ := splitRowKey(k); return strings.HasPrefix(u, prefix) })
+	return n, nil
+}
+
+// PurgeAll empties the cache.
+func (c *Cache) PurgeAll(ctx context.Context) (int64, error) {
+	n, err := c.backend.Delete(ctx, Match{})
+	if err != nil { return 0, err }
+	c.mem.removeIf(func(string) bool { return true })
+	return n, nil
+}
+
+// splitRowKey undoes rowKey; URLs never contain spaces once parsed.
+func splitRowKey(k string) (method, url, contentHash string) {
+	method, rest, _ := strings.Cut(k, " ")
+	i := strings.LastIndex(rest, " ")
+	if i < 0 { return method, rest, "" }
+	return method, rest[:i], rest[i+1:]
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gh-proxy/internal/config"
+)
+
+// testCache is a Cache on a MemoryBackend with the L1 in front, holding one
+// GET entry per URL.
+func testCache(t *testing.T, urls ...string) *Cache {
+	t.Helper()
+	cfg := config.Config{CacheMemoryMB: 1}
+	c := NewWithBackend(NewMemoryBackend(cfg), cfg)
+	for _, u := range urls {
+		if err := c.Put(context.Background(), NewKey("GET", u, nil, nil), nil, 200, []byte(`{}`), []byte(u), time.Hour); err != nil { t.Fatal(err) }
+		// a second lookup is answered from L1, so purges must clear it too
+		if e, _ := c.Lookup(context.Background(), NewKey("GET", u, nil, nil)); e == nil { t.Fatalf("%s not cached", u) }
+	}
+	return c
+}
+
+func cached(c *Cache, u string) bool {
+	e, _ := c.Lookup(context.Background(), NewKey("GET", u, nil, nil))
+	return e != nil
+}
+
+var purgeURLs = []string{
+	"https://api.github.com/repos/o/r",
+	"https://api.github.com/repos/o/r/issues?per_page=100&state=open",
+	"https://api.github.com/repos/o/rx",
+	"https://api.github.com/users/u",
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name string
+		purge func(c *Cache) (int64, error)
+		gone []string
+	}{
+		{"url", func(c *Cache) (int64, error) { return c.PurgeURL(ctx, "https://api.github.com/repos/o/r") }, purgeURLs[:1]},
+		{"url with unsorted query and upper-case host", func(c *Cache) (int64, error) { return c.PurgeURL(ctx, "https://API.github.com/repos/o/r/issues?state=open&per_page=100&page=1") }, purgeURLs[1:2]},
+		{"prefix", func(c *Cache) (int64, error) { return c.PurgePrefix(ctx, "https://api.github.com/repos/o/") }, purgeURLs[:3]},
+		{"prefix with upper-case host", func(c *Cache) (int64, error) { return c.PurgePrefix(ctx, "https://API.GitHub.com/repos/o/r") }, purgeURLs[:3]},
+		{"prefix with unsorted query", func(c *Cache) (int64, error) { return c.PurgePrefix(ctx, "https://api.github.com/repos/o/r/issues?state=open&per_page=100") }, purgeURLs[1:2]},
+		{"prefix ending in ?", func(c *Cache) (int64, error) { return c.PurgePrefix(ctx, "https://api.github.com/repos/o/r/issues?") }, purgeURLs[1:2]},
+		{"prefix matching nothing", func(c *Cache) (int64, error) { return c.PurgePrefix(ctx, "https://api.github.com/orgs/") }, nil},
+		{"everything", func(c *Cache) (int64, error) { return c.PurgeAll(ctx) }, purgeURLs},
+	}
+	for _, tc := range cases {
+		c := testCache(t, purgeURLs...)
+		n, err := tc.purge(c)
+		if err != nil { t.Errorf("%s: %v", tc.name, err); continue }
+		if n != int64(len(tc.gone)) { t.Errorf("%s: purged %d, want %d", tc.name, n, len(tc.gone)) }
+		gone := map[string]bool{}
+		for _, u := range tc.gone { gone[u] = true }
+		for _, u := range purgeURLs {
+			if cached(c, u) == gone[u] { t.Errorf("%s: %s cached = %v, want %v", tc.name, u, gone[u], !gone[u]) }
+		}
+	}
+}
+
+func TestCanonicalPrefix(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{"https://API.GitHub.com/repos/", "https://api.github.com/repos/"},
+		{"https://api.github.com/search/code?q=x&per_page=5", "https://api.github.com/search/code?per_page=5&q=x"},
+		{"https://api.github.com/repos/o/r/issues?", "https://api.github.com/repos/o/r/issues?"},
+	}
+	for _, c := range cases {
+		if got := CanonicalPrefix(c.in); got != c.want { t.Errorf("CanonicalPrefix(%q) = %q, want %q", c.in, got, c.want) }
+	}
+}
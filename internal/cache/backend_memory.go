@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"gh-proxy/internal/config"
+)
+
+// MemoryBackend keeps entries in process memory: the latest one per key,
+// evicted by CACHE_EVICTION once over MAX_CACHE_SIZE_MB. Everything is lost on
+// restart and nothing is shared between instances, so it suits tests and
+// single-instance deployments that don't want the cache in Postgres.
+type MemoryBackend struct {
+	mu sync.Mutex
+	entries map[string]*memStored // by rowKey
+	byID map[int64]*memStored
+	nextID int64
+	usedBytes int64
+	maxBytes int64
+	lowWatermarkPct int64
+	eviction string
+}
+
+type memStored struct {
+	e Entry
+	key, method, url string
+	lastAccess time.Time
+	hits int64
+}
+
+func (s *memStored) size() int64 { return storedBytes(s.e.Headers, s.e.Body) }
+
+func NewMemoryBackend(cfg config.Config) *MemoryBackend {
+	m := &MemoryBackend{
+		entries: map[string]*memStored{},
+		byID: map[int64]*memStored{},
+		maxBytes: cfg.MaxCacheSizeMB * 1024 * 1024,
+		lowWatermarkPct: cfg.CacheLowWatermarkPct,
+		eviction: cfg.CacheEviction,
+	}
+	if m.lowWatermarkPct <= 0 || m.lowWatermarkPct > 100 { m.lowWatermarkPct = 80 }
+	return m
+}
+
+func (m *MemoryBackend) Get(ctx context.Context, k Key) (*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.entries[k.String()]
+	if !ok { return nil, nil }
+	s.lastAccess = time.Now()
+	s.hits++
+	e := s.e
+	return &e, nil
+}
+
+func (m *MemoryBackend) Put(ctx context.Context, k Key, reqBody []byte, e *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	e.ID, e.CreatedAt = m.nextID, time.Now()
+	key := k.String()
+	if old, ok := m.entries[key]; ok { m.removeLocked(old) }
+	s := &memStored{e: *e, key: key, method: k.Method, url: k.URL, lastAccess: e.CreatedAt}
+	m.entries[key] = s
+	m.byID[e.ID] = s
+	m.usedBytes += s.size()
+	return nil
+}
+
+func (m *MemoryBackend) Refresh(ctx context.Context, e *Entry, expiresAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.byID[e.ID]; ok { s.e.ExpiresAt, s.e.CreatedAt = expiresAt, time.Now() }
+	return nil
+}
+
+func (m *MemoryBackend) Touch(e *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.byID[e.ID]; ok {
+		s.lastAccess = time.Now()
+		s.hits++
+	}
+}
+
+func (m *MemoryBackend) Delete(ctx context.Context, match Match) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, s := range m.entries {
+		if match.matches(s.url) {
+			m.removeLocked(s)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryBackend) removeLocked(s *memStored) {
+	delete(m.entries, s.key)
+	delete(m.byID, s.e.ID)
+	m.usedBytes -= s.size()
+}
+
+// Cleanup evicts unpinned entries down to the low watermark once over MAX_CACHE_SIZE_MB.
+func (m *MemoryBackend) Cleanup(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxBytes <= 0 || m.usedBytes <= m.maxBytes { return nil }
+	low := m.maxBytes * m.lowWatermarkPct / 100
+	victims := make([]*memStored, 0, len(m.entries))
+	for _, s := range m.entries {
+		if !s.e.Pinned { victims = append(victims, s) }
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		a, b := victims[i], victims[j]
+		switch m.eviction {
+		case "lfu":
+			if a.hits != b.hits { return a.hits < b.hits }
+			return a.lastAccess.Before(b.lastAccess)
+		case "fifo": return a.e.CreatedAt.Before(b.e.CreatedAt)
+		default: return a.lastAccess.Before(b.lastAccess)
+		}
+	})
+	for _, s := range victims {
+		if m.usedBytes <= low { break }
+		m.removeLocked(s)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Stats() BackendStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return BackendStats{StoredBytes: m.usedBytes}
+}
+
+func (m *MemoryBackend) Search(ctx context.Context, prefix string, limit int) ([]Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []Info{}
+	for _, s := range m.entries {
+		if (Match{Prefix: prefix}).matches(s.url) { out = append(out, s.info(false)) }
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	if len(out) > limit { out = out[:limit] }
+	return out, nil
+}
+
+func (m *MemoryBackend) Inspect(ctx context.Context, id int64) (*Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.byID[id]
+	if !ok { return nil, nil }
+	in := s.info(true)
+	return &in, nil
+}
+
+func (s *memStored) info(headers bool) Info {
+	in := Info{ID: s.e.ID, Method: s.method, URL: s.url, Status: s.e.Status, Encoding: s.e.Encoding, Size: int64(len(s.e.Body)), BodyHash: s.e.BodyHash, CreatedAt: s.e.CreatedAt, ExpiresAt: s.e.ExpiresAt}
+	if headers { _ = json.Unmarshal(s.e.Headers, &in.Headers) }
+	return in
+}
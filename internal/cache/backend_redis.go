@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gh-proxy/internal/config"
+)
+
+// RedisBackend keeps entries in Redis (or a server speaking its protocol), so
+// the hot cache can live off the primary database and be shared between
+// instances. Its size is bounded by the server's maxmemory policy rather than
+// MAX_CACHE_SIZE_MB, pins aren't enforced and the admin inspector is unavailable.
+type RedisBackend struct {
+	rc *redisClient
+	// keep expired entries this long for stale serving and conditional revalidation
+	grace time.Duration
+	// used_memory reported by the server at the last Cleanup
+	usedBytes atomic.Int64
+}
+
+const (
+	redisKeyPrefix = "ghproxy:cache:"
+	redisIDKey     = "ghproxy:cache-id"
+	// minimum time an expired entry is kept so its ETag can still be revalidated
+	redisRevalidateGrace = time.Hour
+)
+
+func NewRedisBackend(cfg config.Config) (*RedisBackend, error) {
+	rc, err := newRedisClient(cfg.RedisURL, 32)
+	if err != nil { return nil, err }
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if _, err := rc.do(ctx, "PING"); err != nil { return nil, fmt.Errorf("connect %s: %w", rc.addr, err) }
+	grace := redisRevalidateGrace
+	for _, d := range []int64{cfg.StaleWhileRevalidate.Duration(), cfg.StaleIfError.Duration()} {
+		if seconds(d) > grace { grace = seconds(d) }
+	}
+	return &RedisBackend{rc: rc, grace: grace}, nil
+}
+
+func redisKey(k Key) string { return redisKeyPrefix + k.String() }
+
+func (rb *RedisBackend) Get(ctx context.Context, k Key) (*Entry, error) {
+	v, err := rb.rc.do(ctx, "GET", redisKey(k))
+	if err != nil { return nil, err }
+	b, _ := v.([]byte)
+	if b == nil { return nil, nil }
+	var e Entry
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&e); err != nil { return nil, err }
+	return &e, nil
+}
+
+func (rb *RedisBackend) Put(ctx context.Context, k Key, reqBody []byte, e *Entry) error {
+	id, err := rb.rc.do(ctx, "INCR", redisIDKey)
+	if err != nil { return err }
+	e.ID, _ = id.(int64)
+	e.CreatedAt = time.Now()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil { return err }
+	args := []any{"SET", redisKey(k), buf.Bytes()}
+	if e.ExpiresAt != nil {
+		ttl := time.Until(*e.ExpiresAt) + rb.grace
+		args = append(args, "PX", ttl.Milliseconds())
+	}
+	_, err = rb.rc.do(ctx, args...)
+	return err
+}
+
+// globEscape makes s match literally in a SCAN MATCH pattern.
+func globEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+func (rb *RedisBackend) Delete(ctx context.Context, m Match) (int64, error) {
+	pattern := redisKeyPrefix + "*"
+	switch {
+	case m.URL != "": pattern = redisKeyPrefix + "* " + globEscape(m.URL) + " *"
+	case m.Prefix != "": pattern = redisKeyPrefix + "* " + globEscape(m.Prefix) + "*"
+	}
+	var n int64
+	cursor := "0"
+	for {
+		v, err := rb.rc.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", 1000)
+		if err != nil { return n, err }
+		reply, _ := v.([]any)
+		if len(reply) != 2 { return n, fmt.Errorf("redis: unexpected SCAN reply") }
+		next, _ := reply[0].([]byte)
+		keys, _ := reply[1].([]any)
+		del := []any{"DEL"}
+		for _, k := range keys {
+			key, _ := k.([]byte)
+			if _, u, _ := splitRowKey(strings.TrimPrefix(string(key), redisKeyPrefix)); m.matches(u) { del = append(del, key) }
+		}
+		if len(del) > 1 {
+			d, err := rb.rc.do(ctx, del...)
+			if err != nil { return n, err }
+			removed, _ := d.(int64)
+			n += removed
+		}
+		cursor = string(next)
+		if cursor == "0" { return n, nil }
+	}
+}
+
+// Cleanup only refreshes the size reported in Stats; Redis expires and evicts entries itself.
+func (rb *RedisBackend) Cleanup(ctx context.Context) error {
+	v, err := rb.rc.do(ctx, "INFO", "memory")
+	if err != nil { return err }
+	info, _ := v.([]byte)
+	for _, line := range strings.Split(string(info), "\r\n") {
+		if rest, ok := strings.CutPrefix(line, "used_memory:"); ok {
+			if n, err := strconv.ParseInt(rest, 10, 64); err == nil { rb.usedBytes.Store(n) }
+		}
+	}
+	return nil
+}
+
+func (rb *RedisBackend) Stats() BackendStats { return BackendStats{StoredBytes: rb.usedBytes.Load()} }
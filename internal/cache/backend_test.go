@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gh-proxy/internal/config"
+)
+
+const testAPI = "https://api.github.com"
+
+func testKey(path string) Key { return NewKey("GET", testAPI+path, nil, nil) }
+
+func testEntry(body string, pinned bool) *Entry {
+	return &Entry{Status: 200, Headers: []byte(`{}`), Body: []byte(body), Encoding: "identity", Pinned: pinned}
+}
+
+func put(t *testing.T, b Backend, path string, e *Entry) {
+	t.Helper()
+	if err := b.Put(context.Background(), testKey(path), nil, e); err != nil { t.Fatal(err) }
+}
+
+func has(t *testing.T, b Backend, path string) bool {
+	t.Helper()
+	e, err := b.Get(context.Background(), testKey(path))
+	if err != nil { t.Fatal(err) }
+	return e != nil
+}
+
+// testBackendContract checks what every Backend must do; b starts empty.
+func testBackendContract(t *testing.T, b Backend) {
+	ctx := context.Background()
+	if has(t, b, "/repos/o/r") { t.Fatal("Get on an empty backend returned an entry") }
+
+	e := testEntry("one", false)
+	put(t, b, "/repos/o/r", e)
+	if e.ID == 0 || e.CreatedAt.IsZero() { t.Fatalf("Put didn't fill in ID and CreatedAt: %+v", e) }
+	got, err := b.Get(ctx, testKey("/repos/o/r"))
+	if err != nil || got == nil || string(got.Body) != "one" || got.ID != e.ID { t.Fatalf("Get = %+v, %v", got, err) }
+
+	// a Put replaces the previous entry for the key
+	e2 := testEntry("two", false)
+	put(t, b, "/repos/o/r", e2)
+	got, _ = b.Get(ctx, testKey("/repos/o/r"))
+	if got == nil || string(got.Body) != "two" || got.ID == e.ID { t.Fatalf("after a second Put, Get = %+v", got) }
+
+	// the request body and vary headers are part of the key
+	if got, _ := b.Get(ctx, NewKey("POST", testAPI+"/repos/o/r", []byte("x"), nil)); got != nil { t.Fatal("different method and body shared an entry") }
+
+	put(t, b, "/repos/o/r/issues", testEntry("issues", false))
+	put(t, b, "/repos/o/other", testEntry("other", false))
+	put(t, b, "/users/u", testEntry("user", false))
+
+	n, err := b.Delete(ctx, Match{URL: CanonicalURL(testAPI + "/repos/o/r")})
+	if err != nil || n != 1 { t.Fatalf("Delete by URL = %d, %v; want 1", n, err) }
+	if has(t, b, "/repos/o/r") || !has(t, b, "/repos/o/r/issues") { t.Fatal("Delete by URL removed the wrong entries") }
+
+	n, err = b.Delete(ctx, Match{Prefix: testAPI + "/repos/o/"})
+	if err != nil || n != 2 { t.Fatalf("Delete by prefix = %d, %v; want 2", n, err) }
+	if has(t, b, "/repos/o/r/issues") || has(t, b, "/repos/o/other") || !has(t, b, "/users/u") { t.Fatal("Delete by prefix removed the wrong entries") }
+
+	put(t, b, "/repos/o/r", testEntry("again", false))
+	n, err = b.Delete(ctx, Match{})
+	if err != nil || n != 2 { t.Fatalf("Delete all = %d, %v; want 2", n, err) }
+	if has(t, b, "/users/u") || has(t, b, "/repos/o/r") { t.Fatal("Delete all left entries behind") }
+}
+
+func TestMemoryBackendContract(t *testing.T) {
+	b := NewMemoryBackend(config.Config{})
+	testBackendContract(t, b)
+	if s := b.Stats(); s.StoredBytes != 0 { t.Fatalf("StoredBytes = %d after deleting everything", s.StoredBytes) }
+}
+
+func TestMemoryBackendCleanup(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend(config.Config{MaxCacheSizeMB: 1, CacheLowWatermarkPct: 80, CacheEviction: "lru"})
+	big := strings.Repeat("x", 300*1024)
+	put(t, b, "/pinned", testEntry(big, true))
+	for _, p := range []string{"/a", "/b", "/c", "/d"} { put(t, b, p, testEntry(big, false)) }
+	// touch /a so it is the most recently used
+	has(t, b, "/a")
+
+	if err := b.Cleanup(ctx); err != nil { t.Fatal(err) }
+	for p, want := range map[string]bool{"/pinned": true, "/a": true, "/b": false, "/c": false, "/d": false} {
+		if has(t, b, p) != want { t.Errorf("after Cleanup, %s present = %v, want %v", p, !want, want) }
+	}
+	if used, low := b.Stats().StoredBytes, int64(1024*1024*80/100); used > low { t.Fatalf("StoredBytes = %d, above the low watermark %d", used, low) }
+
+	// back over the low watermark but under the high one, nothing is evicted
+	put(t, b, "/e", testEntry(big, false))
+	if err := b.Cleanup(ctx); err != nil { t.Fatal(err) }
+	if !has(t, b, "/a") || !has(t, b, "/e") { t.Fatal("Cleanup evicted below the high watermark") }
+}
+
+func TestMemoryBackendCleanupKeepsPinned(t *testing.T) {
+	b := NewMemoryBackend(config.Config{MaxCacheSizeMB: 1, CacheLowWatermarkPct: 50})
+	big := strings.Repeat("x", 400*1024)
+	for _, p := range []string{"/p1", "/p2", "/p3"} { put(t, b, p, testEntry(big, true)) }
+	put(t, b, "/u", testEntry(big, false))
+	if err := b.Cleanup(context.Background()); err != nil { t.Fatal(err) }
+	// only the unpinned entry can go, even though usage stays over the limit
+	if has(t, b, "/u") { t.Fatal("unpinned entry survived") }
+	for _, p := range []string{"/p1", "/p2", "/p3"} {
+		if !has(t, b, p) { t.Fatalf("pinned %s was evicted", p) }
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Response bodies live in cache_blobs keyed by the SHA-256 of the raw body, so
+// identical bodies (empty pages, Accept or query variants of one resource) are
+// stored and counted once. Rows point at their blob through body_hash and a
+// blob is deleted once nothing references it. Rows written before blobs
+// existed keep resp_body inline until moveLegacy relocates them.
+
+// blobs touched this recently are never swept, so a Put reusing an existing
+// blob can't lose it to a concurrent sweep before its row is inserted
+const blobGrace = time.Minute
+
+// putBlob stores a body under its hash unless an identical one is already present.
+func (pg *PostgresBackend) putBlob(ctx context.Context, h string, stored []byte, enc string) error {
+	var inserted bool
+	var size int64
+	// xmax = 0 only for freshly inserted tuples, not for the DO UPDATE path
+	err := pg.pool.QueryRow(ctx, `INSERT INTO cache_blobs(hash, body, encoding, size) VALUES($1,$2,$3,$4) ON CONFLICT (hash) DO UPDATE SET last_used_at=now() RETURNING (xmax = 0), size`, h, stored, enc, len(stored)).Scan(&inserted, &size)
+	if err != nil { return err }
+	if inserted { pg.usedBytes.Add(size) }
+	return nil
+}
+
+// sweepBlobs deletes unreferenced blobs and takes their bytes off the counter.
+// With hashes it only considers those candidates; with nil it scans every blob.
+func (pg *PostgresBackend) sweepBlobs(ctx context.Context, hashes []string) (int64, error) {
+	q := `WITH d AS (DELETE FROM cache_blobs b WHERE b.last_used_at < $1 AND NOT EXISTS (SELECT 1 FROM cached_responses r WHERE r.body_hash=b.hash)`
+	args := []any{time.Now().Add(-blobGrace)}
+	if hashes != nil {
+		if len(hashes) == 0 { return 0, nil }
+		q += ` AND b.hash = ANY($2)`
+		args = append(args, hashes)
+	}
+	q += ` RETURNING size) SELECT count(*), COALESCE(SUM(size),0)::bigint FROM d`
+	var n, freed int64
+	if err := pg.pool.QueryRow(ctx, q, args...).Scan(&n, &freed); err != nil { return 0, err }
+	pg.usedBytes.Add(-freed)
+	return n, nil
+}
+
+// moveLegacy relocates a batch of inline resp_body rows into cache_blobs, so
+// existing caches get deduplicated gradually without a blocking migration.
+// Rows wait until backfillStoredBytes has measured them, so the bytes moved
+// out are taken off a size that included them.
+func (pg *PostgresBackend) moveLegacy(ctx context.Context) error {
+	rows, err := pg.pool.Query(ctx, `SELECT id, resp_body, resp_encoding FROM cached_responses WHERE body_hash IS NULL AND stored_bytes IS NOT NULL ORDER BY id LIMIT 500`)
+	if err != nil { return err }
+	var todo []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.Body, &e.Encoding); err != nil { rows.Close(); return err }
+		todo = append(todo, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { return err }
+	n := 0
+	for _, e := range todo {
+		body, err := e.DecodedBody()
+		if err != nil {
+			log.Printf("cache: dropping row %d with undecodable body: %v", e.ID, err)
+			if _, err := pg.deleteWhere(ctx, `id=$1`, e.ID); err != nil { return err }
+			continue
+		}
+		h := hash(body)
+		stored, enc := encode(body, pg.compression)
+		if err := pg.putBlob(ctx, h, stored, enc); err != nil { return err }
+		tag, err := pg.pool.Exec(ctx, `UPDATE cached_responses SET body_hash=$2, resp_body=NULL, stored_bytes=GREATEST(stored_bytes-$3, 0) WHERE id=$1 AND body_hash IS NULL`, e.ID, h, len(e.Body))
+		if err != nil { return err }
+		if tag.RowsAffected() > 0 { pg.usedBytes.Add(-int64(len(e.Body))) }
+		n++
+	}
+	if n > 0 { log.Printf("cache: moved %d legacy rows into blobs", n) }
+	return nil
+}
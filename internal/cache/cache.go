@@ -3,60 +3,315 @@ package cache
 import (
 	"context"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
 	"time"
 	"log"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"gh-proxy/internal/config"
+	gh "gh-proxy/internal/github"
 )
 
+// Backend stores cache entries. Cache layers TTL policy, the L1 memory cache
+// and stale handling on top of it. Besides Postgres (the default) there are
+// in-memory and Redis backends, picked with CACHE_BACKEND.
+type Backend interface {
+	// Get returns the latest entry stored for k, fresh or expired, or nil.
+	Get(ctx context.Context, k Key) (*Entry, error)
+	// Put stores e as the latest entry for k, filling in e.ID and e.CreatedAt.
+	Put(ctx context.Context, k Key, reqBody []byte, e *Entry) error
+	// Delete removes every entry m selects and returns how many there were.
+	Delete(ctx context.Context, m Match) (int64, error)
+	// Cleanup runs periodic housekeeping such as size-based eviction.
+	Cleanup(ctx context.Context) error
+	Stats() BackendStats
+}
+
+// Optional Backend capabilities.
+
+// Refresher records a successful revalidation in place (new expiry, age
+// restarts); without it Refresh stores a copy.
+type Refresher interface {
+	Refresh(ctx context.Context, e *Entry, expiresAt *time.Time) error
+}
+
+// Toucher is told about hits answered from the L1 cache, for eviction order.
+type Toucher interface {
+	Touch(e *Entry)
+}
+
+// Searcher backs the admin cache inspector.
+type Searcher interface {
+	Search(ctx context.Context, prefix string, limit int) ([]Info, error)
+	Inspect(ctx context.Context, id int64) (*Info, error)
+}
+
+// Repinner re-marks stored entries when CACHE_PIN_PATTERNS changed since
+// they were stored; pinned is nil when no patterns are set. Backends that
+// don't outlive the process (or don't enforce pins) don't need it.
+type Repinner interface {
+	Repin(ctx context.Context, pinned func(url string) bool) (done bool, err error)
+}
+
+// Reclaimer returns space freed by deletes to the OS (CACHE_VACUUM_INTERVAL).
+type Reclaimer interface {
+	Reclaim(ctx context.Context) error
+}
+
+// ErrUnsupported is returned for operations the configured backend can't do.
+var ErrUnsupported = errors.New("not supported by this cache backend")
+
+// Match selects entries for Backend.Delete: one URL, a URL prefix, or (zero value) everything.
+type Match struct {
+	URL    string
+	Prefix string
+}
+
+func (m Match) matches(rawURL string) bool {
+	switch {
+	case m.URL != "": return rawURL == m.URL
+	case m.Prefix != "": return strings.HasPrefix(rawURL, m.Prefix)
+	}
+	return true
+}
+
+// BackendStats reports the logical size of a backend.
+type BackendStats struct {
+	StoredBytes int64
+}
+
 type Cache struct {
-	pool *pgxpool.Pool
+	backend Backend
 	maxAge time.Duration
-	maxSizeMB int64
+	// grace windows past expires_at during which an expired entry may still be served
+	staleWhileRevalidate time.Duration
+	staleIfError time.Duration
+	// per-endpoint TTL rules; first match wins, MAX_CACHE_TIME otherwise
+	rules []Rule
+	// in-process L1 in front of the backend; nil when CACHE_MEMORY_MB=0
+	mem *memCache
+	// short TTL for 404/410 (and optionally 451) responses; 0 disables negative caching
+	negativeTTL time.Duration
+	negative451 bool
+	// path patterns (rule syntax) whose entries are never evicted
+	pins []string
+	// set once the backend's stored pins match pins
+	repinned bool
+	// body encoding for new entries: "gzip" or "identity"
+	compression string
 }
 
-func New(pool *pgxpool.Pool, maxAgeSeconds int64, maxSizeMB int64) *Cache {
-	age := time.Duration(maxAgeSeconds) * time.Second
-	return &Cache{pool: pool, maxAge: age, maxSizeMB: maxSizeMB}
+// New builds the cache on the backend selected by CACHE_BACKEND; pool is only used for "postgres".
+func New(pool *pgxpool.Pool, cfg config.Config) *Cache {
+	var b Backend
+	switch cfg.CacheBackend {
+	case "memory":
+		b = NewMemoryBackend(cfg)
+	case "redis":
+		rb, err := NewRedisBackend(cfg)
+		if err != nil { log.Fatalf("cache: redis backend: %v", err) }
+		b = rb
+	case "", "postgres":
+		b = NewPostgresBackend(pool, cfg)
+	default:
+		log.Fatalf("cache: unknown CACHE_BACKEND %q", cfg.CacheBackend)
+	}
+	return NewWithBackend(b, cfg)
 }
 
+// NewWithBackend builds a cache on an already constructed backend.
+func NewWithBackend(b Backend, cfg config.Config) *Cache {
+	c := &Cache{
+		backend: b,
+		maxAge: seconds(cfg.MaxCacheTime.Duration()),
+		staleWhileRevalidate: seconds(cfg.StaleWhileRevalidate.Duration()),
+		staleIfError: seconds(cfg.StaleIfError.Duration()),
+		compression: EncodingIdentity,
+		negativeTTL: seconds(cfg.NegativeCacheTime.Duration()),
+		negative451: cfg.NegativeCache451,
+		pins: cfg.CachePinPatterns,
+	}
+	if cfg.CacheCompression == EncodingGzip { c.compression = EncodingGzip }
+	if cfg.CacheMemoryMB > 0 { c.mem = newMemCache(cfg.CacheMemoryMB * 1024 * 1024) }
+	if cfg.CacheRulesFile != "" {
+		rules, err := LoadRules(cfg.CacheRulesFile)
+		if err != nil { log.Fatalf("cache rules: %v", err) }
+		c.rules = rules
+		log.Printf("cache: loaded %d TTL rules from %s", len(rules), cfg.CacheRulesFile)
+	}
+	return c
+}
+
+func seconds(n int64) time.Duration { return time.Duration(n) * time.Second }
+
 func hash(b []byte) string {
 	h := sha256.Sum256(b)
 	return hex.EncodeToString(h[:])
 }
 
-func (c *Cache) Get(ctx context.Context, method, url string, body []byte) (status int, headers []byte, resp []byte, ok bool, err error) {
-	contentHash := hash(body)
-	row := c.pool.QueryRow(ctx, `SELECT status, resp_headers, resp_body FROM cached_responses WHERE method=$1 AND url=$2 AND content_hash=$3 AND (expires_at IS NULL OR expires_at > now()) ORDER BY id DESC LIMIT 1`, method, url, contentHash)
-	err = row.Scan(&status, &headers, &resp)
-	if err != nil {
-		if err == sql.ErrNoRows { return 0, nil, nil, false, nil }
-		return
+func rowKey(method, url, contentHash string) string { return method + " " + url + " " + contentHash }
+
+// Entry is the most recent stored response for a request, fresh or expired.
+type Entry struct {
+	ID int64
+	Status int
+	Headers []byte
+	Body []byte // as stored; see Encoding and DecodedBody
+	Encoding string // "identity" or "gzip"
+	BodyHash string // SHA-256 of the decoded body
+	Pinned bool // matched CACHE_PIN_PATTERNS, never evicted
+	CreatedAt time.Time // when GitHub last sent or confirmed (304) the response
+	ExpiresAt *time.Time
+	key string // rowKey, for keeping the L1 copy in sync
+}
+
+func (e *Entry) Fresh() bool { return e.ExpiresAt == nil || e.ExpiresAt.After(time.Now()) }
+
+// Age is how long ago GitHub sent or last confirmed the response.
+func (e *Entry) Age() time.Duration { return time.Since(e.CreatedAt) }
+
+// StaleFor is how long ago the entry expired (0 while fresh).
+func (e *Entry) StaleFor() time.Duration {
+	if e.Fresh() { return 0 }
+	return time.Since(*e.ExpiresAt)
+}
+
+// ServeStale reports whether an expired entry may be served immediately while it is refreshed in the background.
+func (c *Cache) ServeStale(e *Entry) bool { return c.staleWhileRevalidate > 0 && e.StaleFor() <= c.staleWhileRevalidate }
+
+// ServeStaleOnError reports whether an expired entry may stand in for a failed upstream fetch.
+func (c *Cache) ServeStaleOnError(e *Entry) bool { return c.staleIfError > 0 && e.StaleFor() <= c.staleIfError }
+
+// ConditionalHeaders builds If-None-Match / If-Modified-Since from the stored
+// ETag and Last-Modified so an expired entry can be revalidated upstream.
+// Returns nil when the stored response carried no validators.
+func (e *Entry) ConditionalHeaders() http.Header {
+	var stored http.Header
+	if err := json.Unmarshal(e.Headers, &stored); err != nil { return nil }
+	h := http.Header{}
+	if v := stored.Get("ETag"); v != "" { h.Set("If-None-Match", v) }
+	if v := stored.Get("Last-Modified"); v != "" { h.Set("If-Modified-Since", v) }
+	if len(h) == 0 { return nil }
+	return h
+}
+
+// Lookup returns the latest entry for the request even if it has expired, or nil on a miss.
+// Fresh entries are answered from memory when possible. Returned entries must not be modified.
+func (c *Cache) Lookup(ctx context.Context, k Key) (*Entry, error) {
+	key := k.String()
+	if e, ok := c.mem.get(key); ok {
+		if t, ok := c.backend.(Toucher); ok { t.Touch(e) }
+		return e, nil
 	}
-	return status, headers, resp, true, nil
+	e, err := c.backend.Get(ctx, k)
+	if err != nil || e == nil { return nil, err }
+	e.key = key
+	if e.Fresh() { c.mem.add(key, e) }
+	return e, nil
 }
 
-func (c *Cache) Put(ctx context.Context, method, url string, reqBody []byte, status int, respHeaders []byte, respBody []byte) error {
-	var expires *time.Time
-	if c.maxAge > 0 { t := time.Now().Add(c.maxAge); expires = &t } // 0 => unlimited (NULL)
-	_, err := c.pool.Exec(ctx, `INSERT INTO cached_responses(method,url,req_body,status,resp_headers,resp_body,expires_at,content_hash) VALUES($1,$2,$3,$4,$5,$6,$7,$8)`, method, url, reqBody, status, respHeaders, respBody, expires, hash(reqBody))
-	return err
+func (c *Cache) Get(ctx context.Context, k Key) (status int, headers []byte, resp []byte, ok bool, err error) {
+	e, err := c.Lookup(ctx, k)
+	if err != nil || e == nil || !e.Fresh() { return 0, nil, nil, false, err }
+	if resp, err = e.DecodedBody(); err != nil { return 0, nil, nil, false, err }
+	return e.Status, e.Headers, resp, true, nil
 }
 
-func (c *Cache) Cleanup(ctx context.Context) error {
-	// enforce size limit in MB by trimming oldest rows
-	if c.maxSizeMB <= 0 { return nil }
-	// approximate table size using pg_total_relation_size
-	var bytes int64
-	if err := c.pool.QueryRow(ctx, `SELECT COALESCE(pg_total_relation_size('cached_responses')::bigint,0)`).Scan(&bytes); err != nil { return err }
-	limit := c.maxSizeMB * 1024 * 1024
-	if bytes <= limit { return nil }
-	// delete oldest 10% and recheck next time
-	cmdTag, err := c.pool.Exec(ctx, `DELETE FROM cached_responses WHERE id IN (SELECT id FROM cached_responses ORDER BY created_at ASC LIMIT (SELECT GREATEST(1, (SELECT count(*) FROM cached_responses)/10)))`)
-	if err == nil {
-		log.Printf("cache: trimmed %d rows (table ~%d MB)", cmdTag.RowsAffected(), bytes/1024/1024)
+// Refresh extends an entry's expiry by ttl after GitHub confirmed it unchanged (304).
+func (c *Cache) Refresh(ctx context.Context, e *Entry, ttl time.Duration) error {
+	fresh := *e
+	fresh.ExpiresAt = expiry(ttl)
+	fresh.CreatedAt = time.Now()
+	if r, ok := c.backend.(Refresher); ok {
+		if err := r.Refresh(ctx, e, fresh.ExpiresAt); err != nil { return err }
+	} else {
+		var k Key
+		k.Method, k.URL, k.ContentHash = splitRowKey(e.key)
+		if err := c.backend.Put(ctx, k, nil, &fresh); err != nil { return err }
 	}
-	return err
+	c.mem.add(e.key, &fresh)
+	return nil
+}
+
+// IsNegative reports statuses that negative caching may store.
+func IsNegative(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone || status == http.StatusUnavailableForLegalReasons
+}
+
+// NegativeTTL returns how long to cache an error response with this status, if at all.
+func (c *Cache) NegativeTTL(status int) (time.Duration, bool) {
+	if c.negativeTTL <= 0 || !IsNegative(status) { return 0, false }
+	if status == http.StatusUnavailableForLegalReasons && !c.negative451 { return 0, false }
+	return c.negativeTTL, true
+}
+
+// expiry turns a TTL into an expires_at value; 0 => unlimited (NULL)
+func expiry(ttl time.Duration) *time.Time {
+	if ttl <= 0 { return nil }
+	t := time.Now().Add(ttl)
+	return &t
+}
+
+func (c *Cache) Put(ctx context.Context, k Key, reqBody []byte, status int, respHeaders []byte, respBody []byte, ttl time.Duration) error {
+	e := Entry{Status: status, Headers: respHeaders, BodyHash: hash(respBody), Pinned: c.pinned(k.URL), ExpiresAt: expiry(ttl), key: k.String()}
+	e.Body, e.Encoding = encode(respBody, c.compression)
+	if err := c.backend.Put(ctx, k, reqBody, &e); err != nil { return err }
+	c.mem.add(e.key, &e)
+	return nil
+}
+
+// Stats reports L1 memory cache counters and the logical size of the backend.
+type Stats struct {
+	MemHits, MemMisses int64
+	MemEntries int
+	MemBytes int64
+	StoredBytes int64
+}
+
+func (c *Cache) Stats() Stats {
+	st := Stats{StoredBytes: c.backend.Stats().StoredBytes}
+	if c.mem == nil { return st }
+	st.MemHits, st.MemMisses = c.mem.hits.Load(), c.mem.misses.Load()
+	st.MemEntries, st.MemBytes = c.mem.usage()
+	return st
+}
+
+// pinned reports whether a URL matches CACHE_PIN_PATTERNS, which like TTL
+// rules see the path relative to the API root (no GHES /api/v3 prefix).
+func (c *Cache) pinned(rawURL string) bool {
+	if len(c.pins) == 0 { return false }
+	p := gh.APIPath(rawURL)
+	for _, pin := range c.pins {
+		if matchPath(pin, p) { return true }
+	}
+	return false
+}
+
+func (c *Cache) Cleanup(ctx context.Context) error {
+	c.repin(ctx)
+	return c.backend.Cleanup(ctx)
+}
+
+// repin re-evaluates CACHE_PIN_PATTERNS against entries stored before a
+// restart, which were pinned (or not) under whatever patterns were set then.
+// Pins are decided at Put, so once this finishes they stay current.
+func (c *Cache) repin(ctx context.Context) {
+	r, ok := c.backend.(Repinner)
+	if !ok || c.repinned { return }
+	var pinned func(string) bool
+	if len(c.pins) > 0 { pinned = c.pinned }
+	done, err := r.Repin(ctx, pinned)
+	if err != nil { log.Printf("cache: re-mark pinned entries: %v", err) }
+	c.repinned = done
+}
+
+// Reclaim returns space freed by evictions to the OS, if the backend needs that.
+func (c *Cache) Reclaim(ctx context.Context) error {
+	if r, ok := c.backend.(Reclaimer); ok { return r.Reclaim(ctx) }
+	return nil
 }
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+)
+
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+)
+
+// bodies smaller than this aren't worth the gzip header and CPU
+const minCompressBytes = 256
+
+// encode compresses a response body for storage when compression is "gzip".
+// Bodies gzip can't shrink (archives, images, random data) are kept as is.
+func encode(body []byte, compression string) (stored []byte, encoding string) {
+	if compression != EncodingGzip || len(body) < minCompressBytes { return body, EncodingIdentity }
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil { return body, EncodingIdentity }
+	if err := zw.Close(); err != nil { return body, EncodingIdentity }
+	if buf.Len() >= len(body) { return body, EncodingIdentity }
+	return buf.Bytes(), EncodingGzip
+}
+
+// DecodedBody returns the uncompressed response body.
+func (e *Entry) DecodedBody() ([]byte, error) {
+	if e.Encoding != EncodingGzip { return e.Body, nil }
+	zr, err := gzip.NewReader(bytes.NewReader(e.Body))
+	if err != nil { return nil, err }
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// compressLegacy gzips a batch of blobs stored before compression was enabled,
+// so existing caches shrink gradually without a blocking migration. Blobs that
+// don't compress stay identity, so it walks the table once by hash rather than
+// picking the same ones up every time.
+func (pg *PostgresBackend) compressLegacy(ctx context.Context) error {
+	if pg.compression != EncodingGzip || pg.legacyCompressed { return nil }
+	rows, err := pg.pool.Query(ctx, `SELECT hash, body FROM cache_blobs WHERE encoding='identity' AND size >= $1 AND hash > $2 ORDER BY hash LIMIT 500`, minCompressBytes, pg.compressAfter)
+	if err != nil { return err }
+	type pending struct{ hash string; body []byte }
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.hash, &p.body); err != nil { rows.Close(); return err }
+		todo = append(todo, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { return err }
+	if len(todo) < 500 { pg.legacyCompressed = true } else { pg.compressAfter = todo[len(todo)-1].hash }
+	n := 0
+	for _, p := range todo {
+		stored, enc := encode(p.body, pg.compression)
+		if enc == EncodingIdentity { continue }
+		tag, err := pg.pool.Exec(ctx, `UPDATE cache_blobs SET body=$2, encoding=$3, size=$4 WHERE hash=$1 AND encoding='identity'`, p.hash, stored, enc, len(stored))
+		if err != nil { return err }
+		if tag.RowsAffected() > 0 { pg.usedBytes.Add(-int64(len(p.body) - len(stored))) }
+		n++
+	}
+	if n > 0 { log.Printf("cache: compressed %d legacy blobs", n) }
+	return nil
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	_, _ = rand.Read(random)
+	json := []byte(`{"items":[` + strings.Repeat(`{"name":"gh-proxy","stargazers_count":42},`, 100) + `{}]}`)
+	cases := []struct {
+		name string
+		body []byte
+		compression string
+		want string
+	}{
+		{"empty", nil, EncodingGzip, EncodingIdentity},
+		{"below the threshold", json[:minCompressBytes-1], EncodingGzip, EncodingIdentity},
+		{"compressible", json, EncodingGzip, EncodingGzip},
+		{"incompressible", random, EncodingGzip, EncodingIdentity},
+		{"compression off", json, EncodingIdentity, EncodingIdentity},
+		{"unknown compression", json, "br", EncodingIdentity},
+	}
+	for _, c := range cases {
+		stored, enc := encode(c.body, c.compression)
+		if enc != c.want { t.Errorf("%s: encoding = %q, want %q", c.name, enc, c.want); continue }
+		if enc == EncodingGzip && len(stored) >= len(c.body) { t.Errorf("%s: gzip kept at %d bytes for a %d byte body", c.name, len(stored), len(c.body)) }
+		if enc == EncodingIdentity && !bytes.Equal(stored, c.body) { t.Errorf("%s: identity encoding changed the body", c.name) }
+		e := &Entry{Body: stored, Encoding: enc}
+		got, err := e.DecodedBody()
+		if err != nil { t.Errorf("%s: DecodedBody: %v", c.name, err); continue }
+		if !bytes.Equal(got, c.body) { t.Errorf("%s: round trip changed the body", c.name) }
+	}
+}
+
+func TestDecodedBodyCorrupt(t *testing.T) {
+	e := &Entry{Body: []byte("not gzip"), Encoding: EncodingGzip}
+	if _, err := e.DecodedBody(); err == nil { t.Fatal("DecodedBody accepted a corrupt gzip body") }
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are the Cache-Control request directives clients can use to
+// bypass or bound the cache.
+type Directives struct {
+	NoCache      bool          // revalidate with GitHub even when a fresh entry exists
+	NoStore      bool          // don't store the response
+	OnlyIfCached bool          // never go upstream; 504 when nothing usable is cached
+	MaxAge       time.Duration // only accept entries at most this old; negative = any age
+}
+
+// ParseDirectives reads Cache-Control (or, without it, Pragma: no-cache) from a request.
+func ParseDirectives(h http.Header) Directives {
+	d := Directives{MaxAge: -1}
+	cc := h.Values("Cache-Control")
+	if len(cc) == 0 && strings.EqualFold(strings.TrimSpace(h.Get("Pragma")), "no-cache") { d.NoCache = true }
+	for _, v := range cc {
+		for _, part := range strings.Split(v, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch strings.ToLower(name) {
+			case "no-cache": d.NoCache = true
+			case "no-store": d.NoStore = true
+			case "only-if-cached": d.OnlyIfCached = true
+			case "max-age":
+				n, err := strconv.ParseInt(strings.Trim(arg, `"`), 10, 64)
+				if err != nil || n < 0 { continue }
+				// several max-age values: the most restrictive wins
+				if age := seconds(n); d.MaxAge < 0 || age < d.MaxAge { d.MaxAge = age }
+			}
+		}
+	}
+	return d
+}
+
+// UpstreamStorable reports whether GitHub's Cache-Control on a response lets
+// us keep it (no no-cache or no-store).
+func UpstreamStorable(h http.Header) bool {
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-cache") && !strings.Contains(cc, "no-store")
+}
+
+// AgeOK reports whether e is young enough for max-age.
+func (d Directives) AgeOK(e *Entry) bool { return d.MaxAge < 0 || e.Age() <= d.MaxAge }
+
+// Accepts reports whether e may be served without asking GitHub.
+func (d Directives) Accepts(e *Entry) bool { return e.Fresh() && !d.NoCache && d.AgeOK(e) }
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseDirectives(t *testing.T) {
+	none := Directives{MaxAge: -1}
+	cases := []struct {
+		name string
+		h http.Header
+		want Directives
+	}{
+		{"none", http.Header{}, none},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, Directives{NoCache: true, MaxAge: -1}},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, Directives{NoStore: true, MaxAge: -1}},
+		{"only-if-cached", http.Header{"Cache-Control": {"only-if-cached"}}, Directives{OnlyIfCached: true, MaxAge: -1}},
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, Directives{MaxAge: time.Minute}},
+		{"max-age=0", http.Header{"Cache-Control": {"max-age=0"}}, Directives{MaxAge: 0}},
+		{"upper case", http.Header{"Cache-Control": {"NO-CACHE, Max-Age=60"}}, Directives{NoCache: true, MaxAge: time.Minute}},
+		{"spaces", http.Header{"Cache-Control": {"  no-store ,only-if-cached  "}}, Directives{NoStore: true, OnlyIfCached: true, MaxAge: -1}},
+		{"quoted max-age", http.Header{"Cache-Control": {`max-age="30"`}}, Directives{MaxAge: 30 * time.Second}},
+		{"quoted field list", http.Header{"Cache-Control": {`no-cache="Set-Cookie, Authorization", max-age=5`}}, Directives{NoCache: true, MaxAge: 5 * time.Second}},
+		{"bad max-age", http.Header{"Cache-Control": {"max-age=soon"}}, none},
+		{"empty max-age", http.Header{"Cache-Control": {"max-age="}}, none},
+		{"negative max-age", http.Header{"Cache-Control": {"max-age=-1"}}, none},
+		{"bad max-age beside a good one", http.Header{"Cache-Control": {"max-age=x, max-age=10"}}, Directives{MaxAge: 10 * time.Second}},
+		{"several max-age values", http.Header{"Cache-Control": {"max-age=60, max-age=10"}}, Directives{MaxAge: 10 * time.Second}},
+		{"several headers", http.Header{"Cache-Control": {"max-age=10", "no-store, max-age=60"}}, Directives{NoStore: true, MaxAge: 10 * time.Second}},
+		{"unknown directives", http.Header{"Cache-Control": {"private, must-revalidate, stale-if-error=60"}}, none},
+		{"Pragma", http.Header{"Pragma": {"no-cache"}}, Directives{NoCache: true, MaxAge: -1}},
+		{"Pragma case", http.Header{"Pragma": {" No-Cache "}}, Directives{NoCache: true, MaxAge: -1}},
+		{"Pragma ignored beside Cache-Control", http.Header{"Pragma": {"no-cache"}, "Cache-Control": {"max-age=60"}}, Directives{MaxAge: time.Minute}},
+	}
+	for _, c := range cases {
+		if got := ParseDirectives(c.h); got != c.want { t.Errorf("%s: ParseDirectives = %+v, want %+v", c.name, got, c.want) }
+	}
+}
+
+func TestDirectivesAccepts(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+	fresh := &Entry{CreatedAt: time.Now().Add(-30 * time.Second), ExpiresAt: &future}
+	old := &Entry{CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: &future}
+	forever := &Entry{CreatedAt: time.Now().Add(-2 * time.Hour)}
+	expired := &Entry{CreatedAt: time.Now().Add(-30 * time.Second), ExpiresAt: &past}
+	cases := []struct {
+		name string
+		cc string
+		e *Entry
+		accepts, ageOK bool
+	}{
+		{"fresh", "", fresh, true, true},
+		{"expired", "", expired, false, true},
+		{"no expiry", "", forever, true, true},
+		{"no-cache", "no-cache", fresh, false, true},
+		{"no-store only affects storing", "no-store", fresh, true, true},
+		{"max-age above the age", "max-age=60", fresh, true, true},
+		{"max-age below the age", "max-age=10", fresh, false, false},
+		{"max-age=0", "max-age=0", fresh, false, false},
+		{"max-age on an old fresh entry", "max-age=3600", old, false, false},
+		{"max-age on an entry without expiry", "max-age=3600", forever, false, false},
+		{"max-age on an expired young entry", "max-age=60", expired, false, true},
+		{"bad max-age is no limit", "max-age=x", old, true, true},
+	}
+	for _, c := range cases {
+		d := ParseDirectives(http.Header{"Cache-Control": {c.cc}})
+		if got := d.Accepts(c.e); got != c.accepts { t.Errorf("%s: Accepts = %v, want %v", c.name, got, c.accepts) }
+		if got := d.AgeOK(c.e); got != c.ageOK { t.Errorf("%s: AgeOK = %v, want %v", c.name, got, c.ageOK) }
+	}
+}
+
+func TestUpstreamStorable(t *testing.T) {
+	cases := []struct {
+		cc string
+		want bool
+	}{
+		{"", true},
+		{"private, max-age=60, s-maxage=60", true},
+		{"public, max-age=60", true},
+		{"no-cache", false},
+		{"private, No-Store", false},
+		{"max-age=0, no-cache, no-store", false},
+	}
+	for _, c := range cases {
+		if got := UpstreamStorable(http.Header{"Cache-Control": {c.cc}}); got != c.want { t.Errorf("UpstreamStorable(%q) = %v, want %v", c.cc, got, c.want) }
+	}
+}
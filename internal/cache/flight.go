@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// Flight deduplicates concurrent upstream fetches for the same cache key so a
+// burst of identical misses costs one donated-token request instead of N.
+type Flight[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done chan struct{}
+	val  T
+	published bool // done already closed by Publish
+}
+
+func NewFlight[T any]() *Flight[T] { return &Flight[T]{calls: map[string]*flightCall[T]{}} }
+
+// Do runs fn once per key (normally Key.String()) among concurrent callers.
+// The first caller (leader) runs fn; followers wait for its result. leader
+// reports which one this was.
+// A follower whose ctx ends stops waiting; the leader's fn keeps running.
+func (f *Flight[T]) Do(ctx context.Context, key string, fn func() T) (v T, leader bool, err error) {
+	f.mu.Lock()
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.val, false, nil
+		case <-ctx.Done():
+			return v, false, ctx.Err()
+		}
+	}
+	c := &flightCall[T]{done: make(chan struct{})}
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if c.published { return }
+		delete(f.calls, key)
+		c.val, c.published = v, true
+		close(c.done)
+	}()
+	v = fn()
+	return v, true, nil
+}
+
+// Publish hands v to the followers of key's running call before the leader's
+// fn returns, and lets later callers start a call of their own. The leader
+// uses it once it knows the rest of its work is no use to them, e.g. a body
+// too big to share; its own Do still returns what fn returns.
+func (f *Flight[T]) Publish(key string, v T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.calls[key]
+	if !ok || c.published { return }
+	delete(f.calls, key)
+	c.val, c.published = v, true
+	close(c.done)
+}
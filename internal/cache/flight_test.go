@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightCoalesces(t *testing.T) {
+	f := NewFlight[int]()
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	fn := func() int {
+		if calls.Add(1) == 1 { close(started) }
+		<-release
+		return 42
+	}
+	const n = 50
+	var wg sync.WaitGroup
+	var leaders atomic.Int32
+	vals := make([]int, n)
+	run := func(i int) {
+		defer wg.Done()
+		v, leader, err := f.Do(context.Background(), "k", fn)
+		if err != nil { t.Error(err) }
+		if leader { leaders.Add(1) }
+		vals[i] = v
+	}
+	wg.Add(1)
+	go run(0)
+	<-started
+	wg.Add(n - 1)
+	for i := 1; i < n; i++ { go run(i) }
+	// give the followers time to line up behind the leader
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if c := calls.Load(); c != 1 { t.Fatalf("fn ran %d times, want 1", c) }
+	if l := leaders.Load(); l != 1 { t.Fatalf("%d leaders, want 1", l) }
+	for i, v := range vals {
+		if v != 42 { t.Fatalf("caller %d got %d", i, v) }
+	}
+	// finished calls aren't remembered
+	if v, leader, _ := f.Do(context.Background(), "k", func() int { return 7 }); v != 7 || !leader { t.Fatalf("next call got %d (leader %v), want a fresh 7", v, leader) }
+}
+
+func TestFlightKeysIndependent(t *testing.T) {
+	f := NewFlight[string]()
+	release := make(chan struct{})
+	done := make(chan string)
+	go func() {
+		v, _, _ := f.Do(context.Background(), "a", func() string { <-release; return "a" })
+		done <- v
+	}()
+	// a different key doesn't wait on a
+	if v, leader, _ := f.Do(context.Background(), "b", func() string { return "b" }); v != "b" || !leader { t.Fatalf("got %q (leader %v)", v, leader) }
+	close(release)
+	if v := <-done; v != "a" { t.Fatalf("got %q", v) }
+}
+
+func TestFlightPublishReleasesFollowers(t *testing.T) {
+	f := NewFlight[string]()
+	published, release := make(chan struct{}), make(chan struct{})
+	leaderDone := make(chan string)
+	go func() {
+		v, _, _ := f.Do(context.Background(), "k", func() string {
+			<-published
+			f.Publish("k", "too big")
+			<-release
+			return "whole body"
+		})
+		leaderDone <- v
+	}()
+	// wait until the leader's call is registered
+	for {
+		f.mu.Lock()
+		_, ok := f.calls["k"]
+		f.mu.Unlock()
+		if ok { break }
+		time.Sleep(time.Millisecond)
+	}
+	const n = 10
+	got := make(chan string, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			v, leader, err := f.Do(context.Background(), "k", func() string { return "own" })
+			if err != nil || leader { t.Errorf("follower: leader %v, err %v", leader, err) }
+			got <- v
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(published)
+	// followers are released while the leader is still running
+	for i := 0; i < n; i++ {
+		select {
+		case v := <-got:
+			if v != "too big" { t.Fatalf("follower got %q", v) }
+		case <-time.After(time.Second):
+			t.Fatal("followers still waiting after Publish")
+		}
+	}
+	// and later callers start a call of their own
+	if v, leader, _ := f.Do(context.Background(), "k", func() string { return "own" }); v != "own" || !leader { t.Fatalf("caller after Publish got %q (leader %v)", v, leader) }
+	close(release)
+	if v := <-leaderDone; v != "whole body" { t.Fatalf("leader got %q", v) }
+	// a second Publish, or one with nothing running, is a no-op
+	f.Publish("k", "late")
+}
+
+func TestFlightFollowerGivesUp(t *testing.T) {
+	f := NewFlight[int]()
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	go f.Do(context.Background(), "k", func() int { close(started); <-release; return 1 })
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, leader, err := f.Do(ctx, "k", func() int { return 2 }); leader || !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("leader %v, err %v; want a follower's DeadlineExceeded", leader, err) }
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+type graphqlRequest struct {
+	Query         string          `json:"query"`
+	Variables     json.RawMessage `json:"variables"`
+	OperationName string          `json:"operationName"`
+}
+
+// NormalizeGraphQL returns a canonical form of a GraphQL request body for use
+// as the cache key body: insignificant whitespace, commas and comments are
+// dropped and variables are re-encoded with sorted keys. ok is false for
+// mutations, subscriptions and bodies that can't be parsed, which must not be cached.
+func NormalizeGraphQL(body []byte) (normalized []byte, ok bool) {
+	var req graphqlRequest
+	if err := json.Unmarshal(body, &req); err != nil || strings.TrimSpace(req.Query) == "" { return nil, false }
+	query, readOnly := normalizeQuery(req.Query)
+	if !readOnly { return nil, false }
+	var vars any
+	if len(req.Variables) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(req.Variables))
+		dec.UseNumber()
+		if err := dec.Decode(&vars); err != nil { return nil, false }
+	}
+	// maps marshal with sorted keys, which fixes variable ordering
+	out, err := json.Marshal(map[string]any{"query": query, "variables": vars, "operationName": req.OperationName})
+	if err != nil { return nil, false }
+	return out, true
+}
+
+// GraphQLCacheable reports whether a 200 GraphQL response may be stored;
+// responses carrying top-level errors (including RATE_LIMITED) are not.
+func GraphQLCacheable(respBody []byte) bool {
+	var r struct{ Errors []json.RawMessage `json:"errors"` }
+	if err := json.Unmarshal(respBody, &r); err != nil { return false }
+	return len(r.Errors) == 0
+}
+
+// normalizeQuery re-serializes a GraphQL document from its tokens with the
+// minimum whitespace, and reports whether it only contains queries.
+func normalizeQuery(q string) (string, bool) {
+	var out strings.Builder
+	braces, parens := 0, 0
+	prevWord := false
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+			continue
+		case c == '#':
+			for i < len(q) && q[i] != '\n' && q[i] != '\r' { i++ }
+			continue
+		case c == '"':
+			end := stringEnd(q, i)
+			if end < 0 { return "", false }
+			out.WriteString(q[i:end])
+			i = end
+			prevWord = false
+			continue
+		case isNameChar(c) || c == '-':
+			j := i + 1
+			for j < len(q) && (isNameChar(q[j]) || q[j] == '.') { j++ }
+			word := q[i:j]
+			if braces == 0 && parens == 0 && (word == "mutation" || word == "subscription") { return "", false }
+			if prevWord { out.WriteByte(' ') }
+			out.WriteString(word)
+			i = j
+			prevWord = true
+			continue
+		}
+		switch c {
+		case '{':
+			braces++
+		case '}':
+			braces--
+		case '(':
+			parens++
+		case ')':
+			parens--
+		}
+		out.WriteByte(c)
+		i++
+		prevWord = false
+	}
+	if braces != 0 || parens != 0 { return "", false }
+	return out.String(), true
+}
+
+// stringEnd returns the index just past the string or block string starting at i, or -1.
+func stringEnd(q string, i int) int {
+	if strings.HasPrefix(q[i:], `"""`) {
+		for j := i + 3; j+3 <= len(q); j++ {
+			if q[j] == '\\' && strings.HasPrefix(q[j+1:], `"""`) { j += 3; continue }
+			if strings.HasPrefix(q[j:], `"""`) { return j + 3 }
+		}
+		return -1
+	}
+	for j := i + 1; j < len(q); j++ {
+		switch q[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		case '\n', '\r':
+			return -1
+		}
+	}
+	return -1
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
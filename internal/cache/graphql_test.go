@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func gqlBody(query string, vars any) []byte {
+	b, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+	return b
+}
+
+func TestNormalizeGraphQLEquivalent(t *testing.T) {
+	base, ok := NormalizeGraphQL(gqlBody(`query($o:String!){repository(owner:$o,name:"r"){stargazerCount}}`, map[string]any{"o": "hackclub", "n": 1}))
+	if !ok { t.Fatal("base query not cacheable") }
+	same := []struct {
+		name string
+		body []byte
+	}{
+		{"whitespace and commas", gqlBody("query ( $o : String! ) {\n  repository(owner: $o, name: \"r\") {\n    stargazerCount\n  }\n}\n", map[string]any{"o": "hackclub", "n": 1})},
+		{"comments", gqlBody("# stars\nquery($o:String!){ # owner\nrepository(owner:$o,name:\"r\"){stargazerCount}}", map[string]any{"o": "hackclub", "n": 1})},
+		{"tabs and CRLF", gqlBody("query($o:String!)\r\n{\trepository(owner:$o,name:\"r\")\r\n{stargazerCount}}", map[string]any{"o": "hackclub", "n": 1})},
+		{"variable order", []byte(`{"variables":{"n":1,"o":"hackclub"},"query":"query($o:String!){repository(owner:$o,name:\"r\"){stargazerCount}}"}`)},
+	}
+	for _, c := range same {
+		got, ok := NormalizeGraphQL(c.body)
+		if !ok { t.Errorf("%s: not cacheable", c.name); continue }
+		if string(got) != string(base) { t.Errorf("%s: normalized to\n%s\nwant\n%s", c.name, got, base) }
+	}
+	different := []struct {
+		name string
+		body []byte
+	}{
+		{"variable value", gqlBody(`query($o:String!){repository(owner:$o,name:"r"){stargazerCount}}`, map[string]any{"o": "other", "n": 1})},
+		{"string literal", gqlBody(`query($o:String!){repository(owner:$o,name:"r2"){stargazerCount}}`, map[string]any{"o": "hackclub", "n": 1})},
+		{"whitespace inside a string", gqlBody(`query($o:String!){repository(owner:$o,name:"r "){stargazerCount}}`, map[string]any{"o": "hackclub", "n": 1})},
+		{"field", gqlBody(`query($o:String!){repository(owner:$o,name:"r"){forkCount}}`, map[string]any{"o": "hackclub", "n": 1})},
+	}
+	for _, c := range different {
+		got, ok := NormalizeGraphQL(c.body)
+		if ok && string(got) == string(base) { t.Errorf("%s: shares the normalized form", c.name) }
+	}
+}
+
+func TestNormalizeGraphQLQuery(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"{ viewer { login } }", "{viewer{login}}"},
+		{"query Q { viewer { login } }", "query Q{viewer{login}}"},
+		{"{ a: viewer { login } }", "{a:viewer{login}}"},
+		{"{ search(query: \"a # not a comment\") { issueCount } }", "{search(query:\"a # not a comment\"){issueCount}}"},
+		{"{ search(query: \"a, b\") { issueCount } }", "{search(query:\"a, b\"){issueCount}}"},
+		{"{ x(q: \"\"\"block\n  string\"\"\") }", "{x(q:\"\"\"block\n  string\"\"\")}"},
+		{"{ user(login: \"u\") { ...F } } fragment F on User { login }", "{user(login:\"u\"){...F}}fragment F on User{login}"},
+		{"{ repository { issues(first: -1) { totalCount } } }", "{repository{issues(first:-1){totalCount}}}"},
+		// fields that happen to be called mutation are fine inside a selection
+		{"{ viewer { mutation } }", "{viewer{mutation}}"},
+	}
+	for _, c := range cases {
+		got, ok := normalizeQuery(c.in)
+		if !ok || got != c.want { t.Errorf("normalizeQuery(%q) = %q, %v; want %q", c.in, got, ok, c.want) }
+	}
+}
+
+func TestNormalizeGraphQLNotCacheable(t *testing.T) {
+	cases := map[string][]byte{
+		"mutation": gqlBody(`mutation { addStar(input: {starrableId: "x"}) { clientMutationId } }`, nil),
+		"named mutation": gqlBody("# star it\nmutation Star($id: ID!) { addStar(input: {starrableId: $id}) { clientMutationId } }", map[string]any{"id": "x"}),
+		"mutation after a query": gqlBody(`query Q { viewer { login } } mutation M { addStar(input: {}) { clientMutationId } }`, nil),
+		"subscription": gqlBody(`subscription { issueUpdated { id } }`, nil),
+		"unbalanced braces": gqlBody(`{ viewer { login }`, nil),
+		"unterminated string": gqlBody(`{ search(query: "a) { issueCount } }`, nil),
+		"empty query": gqlBody("  ", nil),
+		"not JSON": []byte(`query { viewer { login } }`),
+		"bad variables": []byte(`{"query":"{viewer{login}}","variables":[1,}`),
+	}
+	for name, body := range cases {
+		if _, ok := NormalizeGraphQL(body); ok { t.Errorf("%s: reported cacheable", name) }
+	}
+}
+
+func TestGraphQLCacheable(t *testing.T) {
+	cases := []struct {
+		body string
+		want bool
+	}{
+		{`{"data":{"viewer":{"login":"u"}}}`, true},
+		{`{"data":{"viewer":null},"errors":null}`, true},
+		{`{"data":{"viewer":{"login":"u"}},"errors":[]}`, true},
+		{`{"data":null,"errors":[{"type":"RATE_LIMITED","message":"API rate limit exceeded"}]}`, false},
+		{`{"data":{"repository":null},"errors":[{"type":"NOT_FOUND","path":["repository"]}]}`, false},
+		{`not json`, false},
+	}
+	for _, c := range cases {
+		if got := GraphQLCacheable([]byte(c.body)); got != c.want { t.Errorf("GraphQLCacheable(%s) = %v, want %v", c.body, got, c.want) }
+	}
+}
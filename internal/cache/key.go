@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Defaults the upstream client sends when the caller doesn't; requests that
+// omit these headers share entries with requests that send the defaults.
+const (
+	DefaultAccept     = "application/vnd.github+json"
+	DefaultAPIVersion = "2022-11-28"
+)
+
+// VaryHeaders are the request headers that change GitHub's response body and
+// therefore take part in the cache key. They are also forwarded upstream.
+var VaryHeaders = []string{"Accept", "X-GitHub-Api-Version"}
+
+// Key identifies a cached response: (method, url, content_hash) as stored in cached_responses.
+type Key struct {
+	Method      string
+	URL         string // canonical form, see CanonicalURL
+	ContentHash string // request body plus any non-default VaryHeaders
+}
+
+// NewKey builds the cache key for an upstream request.
+func NewKey(method, rawURL string, body []byte, h http.Header) Key {
+	return Key{Method: method, URL: CanonicalURL(rawURL), ContentHash: contentHash(body, h)}
+}
+
+func (k Key) String() string { return rowKey(k.Method, k.URL, k.ContentHash) }
+
+// CanonicalURL sorts query parameters and drops ones that don't change the
+// response, so ?per_page=100&page=2 and ?page=2&per_page=100 share an entry.
+func CanonicalURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil { return raw }
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.ForceQuery = false // a bare trailing "?" is the same request
+	q := u.Query()
+	for k, vs := range q {
+		kept := vs[:0]
+		for _, v := range vs {
+			if noopParam(k, v) { continue }
+			kept = append(kept, v)
+		}
+		if len(kept) == 0 { delete(q, k); continue }
+		q[k] = kept
+	}
+	u.RawQuery = q.Encode() // sorted by key
+	return u.String()
+}
+
+// CanonicalPrefix puts a URL prefix in the form CanonicalURL stores, so a
+// prefix with an upper-case host or unsorted query still matches. A trailing
+// "?" is kept, so "…/issues?" matches only query variants of that path.
+func CanonicalPrefix(prefix string) string {
+	if prefix == "" { return "" }
+	c := CanonicalURL(prefix)
+	if strings.HasSuffix(prefix, "?") && !strings.Contains(c, "?") { c += "?" }
+	return c
+}
+
+// noopParam reports query params equivalent to leaving them out.
+func noopParam(k, v string) bool {
+	if v == "" { return true }
+	return k == "page" && v == "1"
+}
+
+// contentHash keeps hash(body) for default headers so rows stored before
+// headers were part of the key still match.
+func contentHash(body []byte, h http.Header) string {
+	var vary []string
+	if a := normalizeAccept(h.Get("Accept")); a != DefaultAccept { vary = append(vary, "accept="+a) }
+	if v := strings.TrimSpace(h.Get("X-GitHub-Api-Version")); v != "" && v != DefaultAPIVersion { vary = append(vary, "x-github-api-version="+v) }
+	if len(vary) == 0 { return hash(body) }
+	b := append(append([]byte{}, body...), 0)
+	return hash(append(b, strings.Join(vary, "\n")...))
+}
+
+// normalizeAccept lower-cases and sorts media ranges; "*/*" and empty mean the default.
+func normalizeAccept(a string) string {
+	var parts []string
+	for _, p := range strings.Split(a, ",") {
+		if p = strings.ToLower(strings.ReplaceAll(p, " ", "")); p != "" { parts = append(parts, p) }
+	}
+	if len(parts) == 0 || (len(parts) == 1 && parts[0] == "*/*") { return DefaultAccept }
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"https://api.github.com/repos/o/r", "https://api.github.com/repos/o/r"},
+		{"https://api.github.com/repos/o/r/issues?state=open&per_page=100", "https://api.github.com/repos/o/r/issues?per_page=100&state=open"},
+		{"https://api.github.com/repos/o/r/issues?per_page=100&state=open", "https://api.github.com/repos/o/r/issues?per_page=100&state=open"},
+		{"https://api.github.com/repos/o/r/issues?page=1", "https://api.github.com/repos/o/r/issues"},
+		{"https://api.github.com/repos/o/r/issues?page=2", "https://api.github.com/repos/o/r/issues?page=2"},
+		{"https://api.github.com/repos/o/r/issues?state=&sort=created", "https://api.github.com/repos/o/r/issues?sort=created"},
+		{"https://api.github.com/repos/o/r/issues?labels=b&labels=a", "https://api.github.com/repos/o/r/issues?labels=b&labels=a"},
+		{"https://api.github.com/search/code?q=a+b", "https://api.github.com/search/code?q=a+b"},
+		{"https://API.GitHub.com/repos/O/R", "https://api.github.com/repos/O/R"},
+		{"https://api.github.com/repos/o/r#readme", "https://api.github.com/repos/o/r"},
+		{"https://api.github.com/repos/o/r?", "https://api.github.com/repos/o/r"},
+	}
+	for _, c := range cases {
+		if got := CanonicalURL(c.in); got != c.want { t.Errorf("CanonicalURL(%q) = %q, want %q", c.in, got, c.want) }
+	}
+}
+
+func TestNormalizeAccept(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", DefaultAccept},
+		{"*/*", DefaultAccept},
+		{" */* ", DefaultAccept},
+		{"application/vnd.github+json", DefaultAccept},
+		{"application/vnd.github.raw+json", "application/vnd.github.raw+json"},
+		{"Application/VND.GitHub.Raw+JSON", "application/vnd.github.raw+json"},
+		{"text/plain, application/json", "application/json,text/plain"},
+		{"application/json,text/plain", "application/json,text/plain"},
+		{"*/*, application/json", "*/*,application/json"},
+	}
+	for _, c := range cases {
+		if got := normalizeAccept(c.in); got != c.want { t.Errorf("normalizeAccept(%q) = %q, want %q", c.in, got, c.want) }
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	const u = "https://api.github.com/repos/o/r/issues?state=open&per_page=100"
+	base := NewKey("GET", u, nil, nil)
+	same := []struct {
+		name string
+		k Key
+	}{
+		{"reordered query", NewKey("GET", "https://api.github.com/repos/o/r/issues?per_page=100&state=open&page=1", nil, nil)},
+		{"*/* Accept", NewKey("GET", u, nil, http.Header{"Accept": {"*/*"}})},
+		{"default Accept", NewKey("GET", u, nil, http.Header{"Accept": {DefaultAccept}})},
+		{"default API version", NewKey("GET", u, nil, http.Header{"X-Github-Api-Version": {DefaultAPIVersion}})},
+		{"unrelated header", NewKey("GET", u, nil, http.Header{"User-Agent": {"curl"}})},
+	}
+	for _, c := range same {
+		if c.k != base { t.Errorf("%s: key %v differs from %v", c.name, c.k, base) }
+	}
+	different := []struct {
+		name string
+		k Key
+	}{
+		{"method", NewKey("HEAD", u, nil, nil)},
+		{"query value", NewKey("GET", "https://api.github.com/repos/o/r/issues?state=closed&per_page=100", nil, nil)},
+		{"body", NewKey("GET", u, []byte("x"), nil)},
+		{"raw Accept", NewKey("GET", u, nil, http.Header{"Accept": {"application/vnd.github.raw+json"}})},
+		{"API version", NewKey("GET", u, nil, http.Header{"X-Github-Api-Version": {"2026-03-10"}})},
+	}
+	for _, c := range different {
+		if c.k == base { t.Errorf("%s: shares the key %v", c.name, base) }
+	}
+	// without vary headers the content hash is the plain body hash, as stored before they were keyed
+	if base.ContentHash != hash(nil) { t.Errorf("default headers changed the content hash") }
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// memCache is a byte-bounded in-process LRU that sits in front of the backend
+// so hot entries skip the round trip. Only fresh entries are served.
+type memCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+type memItem struct {
+	key  string
+	e    *Entry
+	size int64
+}
+
+// rough per-entry bookkeeping overhead on top of headers+body
+const memItemOverhead = 256
+
+func newMemCache(maxBytes int64) *memCache {
+	return &memCache{maxBytes: maxBytes, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (m *memCache) get(key string) (*Entry, bool) {
+	if m == nil { return nil, false }
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok { m.misses.Add(1); return nil, false }
+	it := el.Value.(*memItem)
+	if !it.e.Fresh() {
+		m.removeElement(el)
+		m.misses.Add(1)
+		return nil, false
+	}
+	m.ll.MoveToFront(el)
+	m.hits.Add(1)
+	return it.e, true
+}
+
+// add stores e under key. Entries are treated as immutable once added.
+func (m *memCache) add(key string, e *Entry) {
+	if m == nil { return }
+	size := int64(len(e.Headers)+len(e.Body)) + memItemOverhead
+	// don't let one big response flush a large part of the cache
+	if size > m.maxBytes/8 { m.remove(key); return }
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok { m.removeElement(el) }
+	m.items[key] = m.ll.PushFront(&memItem{key: key, e: e, size: size})
+	m.bytes += size
+	for m.bytes > m.maxBytes {
+		m.removeElement(m.ll.Back())
+	}
+}
+
+func (m *memCache) remove(key string) {
+	if m == nil { return }
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok { m.removeElement(el) }
+}
+
+// removeIf drops every entry whose key matches.
+func (m *memCache) removeIf(match func(key string) bool) {
+	if m == nil { return }
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, el := range m.items {
+		if match(k) { m.removeElement(el) }
+	}
+}
+
+func (m *memCache) removeElement(el *list.Element) {
+	it := el.Value.(*memItem)
+	m.ll.Remove(el)
+	delete(m.items, it.key)
+	m.bytes -= it.size
+}
+
+func (m *memCache) usage() (entries int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ll.Len(), m.bytes
+}
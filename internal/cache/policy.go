@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Rule sets how long responses for matching requests are cached. Rules are
+// checked in file order and the first match wins; empty fields match anything.
+//
+// Path is relative to the API root (e.g. "/repos/*/*/commits"). "*" matches a
+// single path segment and a trailing "/**" matches any remaining segments.
+type Rule struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	TTL      int64  `json:"ttl"` // seconds; 0 = never expires
+	NoCache  bool   `json:"no_cache"`
+}
+
+// Policy is the outcome of matching a request against the rule table.
+type Policy struct {
+	Rule  string // matched rule name, or "default"
+	TTL   time.Duration
+	Store bool
+}
+
+// LoadRules reads a JSON array of rules from file.
+func LoadRules(file string) ([]Rule, error) {
+	b, err := os.ReadFile(file)
+	if err != nil { return nil, err }
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil { return nil, fmt.Errorf("parse %s: %w", file, err) }
+	for i := range rules {
+		if rules[i].Path != "" {
+			if _, err := path.Match(rules[i].Path, ""); err != nil { return nil, fmt.Errorf("rule %d: bad path %q: %w", i, rules[i].Path, err) }
+		}
+		if rules[i].Name == "" { rules[i].Name = fmt.Sprintf("%s %s", rules[i].Method, rules[i].Path) }
+		rules[i].Name = strings.TrimSpace(rules[i].Name)
+	}
+	return rules, nil
+}
+
+// PolicyFor picks the TTL for a request from the rule table, falling back to MAX_CACHE_TIME.
+func (c *Cache) PolicyFor(category, method, urlPath string) Policy {
+	for _, r := range c.rules {
+		if r.Category != "" && !strings.EqualFold(r.Category, category) { continue }
+		if r.Method != "" && !strings.EqualFold(r.Method, method) { continue }
+		if r.Path != "" && !matchPath(r.Path, urlPath) { continue }
+		return Policy{Rule: r.Name, TTL: seconds(r.TTL), Store: !r.NoCache}
+	}
+	return Policy{Rule: "default", TTL: c.maxAge, Store: true}
+}
+
+func matchPath(pattern, p string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	ss := strings.Split(strings.Trim(p, "/"), "/")
+	if n := len(ps); ps[n-1] == "**" {
+		ps = ps[:n-1]
+		if len(ss) < len(ps) { return false }
+		ss = ss[:len(ps)]
+	}
+	if len(ps) != len(ss) { return false }
+	for i := range ps {
+		if ok, _ := path.Match(ps[i], ss[i]); !ok { return false }
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want bool
+	}{
+		{"/repos/*/*", "/repos/o/r", true},
+		{"/repos/*/*", "/repos/o/r/", true},
+		{"repos/*/*", "/repos/o/r", true},
+		{"/repos/*/*", "/repos/o", false},
+		{"/repos/*/*", "/repos/o/r/issues", false},
+		{"/repos/*/*/commits", "/repos/o/r/commits", true},
+		{"/repos/*/*/commits", "/repos/o/r/pulls", false},
+		{"/repos/hackclub/*", "/repos/hackclub/gh-proxy", true},
+		{"/repos/hackclub/*", "/repos/other/gh-proxy", false},
+		{"/repos/*/gh-*", "/repos/o/gh-proxy", true},
+		{"/repos/*/gh-*", "/repos/o/proxy", false},
+		{"/users/?", "/users/a", true},
+		{"/users/?", "/users/ab", false},
+		{"/repos/*/*/contents/[a-m]*", "/repos/o/r/contents/docs", true},
+		{"/repos/*/*/contents/[a-m]*", "/repos/o/r/contents/src", false},
+		// * never crosses a segment
+		{"/repos/*", "/repos/o/r", false},
+		// a trailing ** takes any remaining segments, including none
+		{"/repos/*/*/**", "/repos/o/r", true},
+		{"/repos/*/*/**", "/repos/o/r/contents/a/b/c.go", true},
+		{"/repos/*/*/**", "/repos/o", false},
+		{"/**", "/anything/at/all", true},
+		// ** only means "the rest" at the end
+		{"/repos/**/commits", "/repos/o/r/commits", false},
+		{"/search/code", "/search/code", true},
+		{"/search/code", "/search/codes", false},
+		{"/search", "/search/code", false},
+	}
+	for _, c := range cases {
+		if got := matchPath(c.pattern, c.path); got != c.want { t.Errorf("matchPath(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want) }
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	c := &Cache{maxAge: time.Hour, rules: []Rule{
+		{Name: "no search", Category: "search", NoCache: true},
+		{Name: "commits", Method: "GET", Path: "/repos/*/*/commits/**", TTL: 86400},
+		{Name: "repo", Path: "/repos/*/*", TTL: 60},
+		{Name: "forever", Path: "/emojis"},
+	}}
+	cases := []struct {
+		category, method, path string
+		want Policy
+	}{
+		{"search", "GET", "/search/issues", Policy{Rule: "no search", TTL: 0, Store: false}},
+		{"SEARCH", "GET", "/search/issues", Policy{Rule: "no search", TTL: 0, Store: false}},
+		{"core", "GET", "/repos/o/r/commits/abc", Policy{Rule: "commits", TTL: 24 * time.Hour, Store: true}},
+		{"core", "get", "/repos/o/r/commits", Policy{Rule: "commits", TTL: 24 * time.Hour, Store: true}},
+		{"core", "HEAD", "/repos/o/r/commits/abc", Policy{Rule: "default", TTL: time.Hour, Store: true}},
+		{"core", "GET", "/repos/o/r", Policy{Rule: "repo", TTL: time.Minute, Store: true}},
+		{"core", "GET", "/emojis", Policy{Rule: "forever", TTL: 0, Store: true}},
+		{"core", "GET", "/users/u", Policy{Rule: "default", TTL: time.Hour, Store: true}},
+	}
+	for _, tc := range cases {
+		if got := c.PolicyFor(tc.category, tc.method, tc.path); got != tc.want { t.Errorf("PolicyFor(%s, %s, %s) = %+v, want %+v", tc.category, tc.method, tc.path, got, tc.want) }
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil { t.Fatal(err) }
+		return p
+	}
+	rules, err := LoadRules(write("ok.json", `[{"method":"GET","path":"/repos/*/*","ttl":60},{"name":" named ","path":"/users/*"}]`))
+	if err != nil { t.Fatal(err) }
+	if len(rules) != 2 || rules[0].Name != "GET /repos/*/*" || rules[1].Name != "named" { t.Fatalf("rules = %+v", rules) }
+	if _, err := LoadRules(write("bad-path.json", `[{"path":"/repos/[o"}]`)); err == nil { t.Error("accepted a malformed path pattern") }
+	if _, err := LoadRules(write("bad-json.json", `{"path":"/repos"}`)); err == nil { t.Error("accepted a non-array file") }
+}
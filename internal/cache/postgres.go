@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"gh-proxy/internal/config"
+	"gh-proxy/internal/db"
+)
+
+// PostgresBackend keeps entries in cached_responses with bodies deduplicated
+// into cache_blobs (see blob.go). It is the default backend.
+type PostgresBackend struct {
+	pool db.DB
+	maxSizeMB int64
+	// eviction order once over MAX_CACHE_SIZE_MB: "lru", "lfu" or "fifo"
+	eviction string
+	access *accessLog
+	// logical bytes stored (see usage.go) and eviction watermark
+	usedBytes atomic.Int64
+	usageSyncedAt time.Time
+	lowWatermarkPct int64
+	// encoding for legacy rows and blobs recompressed by the janitor
+	compression string
+	// compressLegacy's progress: the last blob hash it looked at, and whether it is done
+	compressAfter string
+	legacyCompressed bool
+	// Repin's progress: the last row id it looked at
+	repinAfter int64
+}
+
+func NewPostgresBackend(pool db.DB, cfg config.Config) *PostgresBackend {
+	pg := &PostgresBackend{
+		pool: pool,
+		maxSizeMB: cfg.MaxCacheSizeMB,
+		eviction: "lru",
+		access: newAccessLog(),
+		lowWatermarkPct: cfg.CacheLowWatermarkPct,
+		compression: EncodingIdentity,
+	}
+	if pg.lowWatermarkPct <= 0 || pg.lowWatermarkPct > 100 { pg.lowWatermarkPct = 80 }
+	switch cfg.CacheEviction {
+	case "lfu", "fifo": pg.eviction = cfg.CacheEviction
+	}
+	if cfg.CacheCompression == EncodingGzip { pg.compression = EncodingGzip }
+	return pg
+}
+
+func (pg *PostgresBackend) Get(ctx context.Context, k Key) (*Entry, error) {
+	var e Entry
+	// legacy rows carry their body inline; rows whose blob has gone missing count as misses
+	row := pg.pool.QueryRow(ctx, `SELECT r.id, r.status, r.resp_headers, COALESCE(b.body, r.resp_body), COALESCE(b.encoding, r.resp_encoding), COALESCE(r.body_hash, ''), r.pinned, r.created_at, r.expires_at FROM cached_responses r LEFT JOIN cache_blobs b ON b.hash=r.body_hash WHERE r.method=$1 AND r.url=$2 AND r.content_hash=$3 AND (r.body_hash IS NULL OR b.hash IS NOT NULL) ORDER BY r.id DESC LIMIT 1`, k.Method, k.URL, k.ContentHash)
+	if err := row.Scan(&e.ID, &e.Status, &e.Headers, &e.Body, &e.Encoding, &e.BodyHash, &e.Pinned, &e.CreatedAt, &e.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) { return nil, nil }
+		return nil, err
+	}
+	pg.access.record(e.ID)
+	return &e, nil
+}
+
+func (pg *PostgresBackend) Put(ctx context.Context, k Key, reqBody []byte, e *Entry) error {
+	if err := pg.putBlob(ctx, e.BodyHash, e.Body, e.Encoding); err != nil { return err }
+	size := storedBytes(e.Headers, nil)
+	err := pg.pool.QueryRow(ctx, `INSERT INTO cached_responses(method,url,req_body,status,resp_headers,body_hash,resp_encoding,expires_at,content_hash,pinned,stored_bytes) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING id, created_at`, k.Method, k.URL, reqBody, e.Status, e.Headers, e.BodyHash, e.Encoding, e.ExpiresAt, k.ContentHash, e.Pinned, size).Scan(&e.ID, &e.CreatedAt)
+	if err != nil { return err }
+	pg.usedBytes.Add(size)
+	return nil
+}
+
+func (pg *PostgresBackend) Refresh(ctx context.Context, e *Entry, expiresAt *time.Time) error {
+	_, err := pg.pool.Exec(ctx, `UPDATE cached_responses SET expires_at=$2, created_at=now() WHERE id=$1`, e.ID, expiresAt)
+	return err
+}
+
+func (pg *PostgresBackend) Touch(e *Entry) { pg.access.record(e.ID) }
+
+func (pg *PostgresBackend) Delete(ctx context.Context, m Match) (int64, error) {
+	switch {
+	case m.URL != "": return pg.deleteWhere(ctx, `url=$1`, m.URL)
+	case m.Prefix != "": return pg.deleteWhere(ctx, `url LIKE $1`, likePrefix(m.Prefix))
+	}
+	return pg.deleteWhere(ctx, `true`)
+}
+
+func (pg *PostgresBackend) Stats() BackendStats { return BackendStats{StoredBytes: pg.usedBytes.Load()} }
+
+// evictionOrder is the ORDER BY that puts the first rows to evict first.
+func (pg *PostgresBackend) evictionOrder() string {
+	switch pg.eviction {
+	case "lfu": return "hit_count ASC, last_accessed_at ASC"
+	case "fifo": return "created_at ASC"
+	default: return "last_accessed_at ASC"
+	}
+}
+
+func (pg *PostgresBackend) Cleanup(ctx context.Context) error {
+	if err := pg.flushAccess(ctx); err != nil { log.Printf("cache: flush access stats: %v", err) }
+	if err := pg.backfillStoredBytes(ctx); err != nil { log.Printf("cache: backfill stored bytes: %v", err) }
+	if err := pg.moveLegacy(ctx); err != nil { log.Printf("cache: move legacy rows: %v", err) }
+	if err := pg.compressLegacy(ctx); err != nil { log.Printf("cache: compress legacy blobs: %v", err) }
+	if _, err := pg.sweepBlobs(ctx, nil); err != nil { log.Printf("cache: sweep blobs: %v", err) }
+	if err := pg.syncUsage(ctx, pg.usageSyncedAt.IsZero()); err != nil { return err }
+	// enforce size limit in MB by evicting unpinned rows in eviction order
+	if pg.maxSizeMB <= 0 { return nil }
+	return pg.evict(ctx)
+}
+
+// re-mark this many rows per batch, and at most repinBatches batches per Cleanup
+const (
+	repinBatch = 1000
+	repinBatches = 20
+)
+
+// Repin brings the pinned column in line with the current CACHE_PIN_PATTERNS a
+// few batches at a time. done reports when every row has been looked at.
+func (pg *PostgresBackend) Repin(ctx context.Context, pinned func(url string) bool) (done bool, err error) {
+	if pinned == nil {
+		_, err := pg.pool.Exec(ctx, `UPDATE cached_responses SET pinned=false WHERE pinned`)
+		return err == nil, err
+	}
+	for i := 0; i < repinBatches; i++ {
+		rows, err := pg.pool.Query(ctx, `SELECT id, url, pinned FROM cached_responses WHERE id > $1 ORDER BY id LIMIT $2`, pg.repinAfter, repinBatch)
+		if err != nil { return false, err }
+		var pin, unpin []int64
+		n, last := 0, pg.repinAfter
+		for rows.Next() {
+			var id int64
+			var u string
+			var was bool
+			if err := rows.Scan(&id, &u, &was); err != nil { rows.Close(); return false, err }
+			n, last = n+1, id
+			if is := pinned(u); is && !was { pin = append(pin, id) } else if !is && was { unpin = append(unpin, id) }
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil { return false, err }
+		for _, set := range []struct{ ids []int64; pinned bool }{{pin, true}, {unpin, false}} {
+			if len(set.ids) == 0 { continue }
+			if _, err := pg.pool.Exec(ctx, `UPDATE cached_responses SET pinned=$2 WHERE id = ANY($1)`, set.ids, set.pinned); err != nil { return false, err }
+		}
+		if len(pin)+len(unpin) > 0 { log.Printf("cache: CACHE_PIN_PATTERNS pinned %d and unpinned %d stored rows", len(pin), len(unpin)) }
+		pg.repinAfter = last
+		if n < repinBatch { pg.repinAfter = 0; return true, nil }
+	}
+	return false, nil
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisClient is a minimal RESP2 client: enough commands for RedisBackend
+// without pulling in a driver. Connections are pooled and dropped on any error.
+type redisClient struct {
+	addr string
+	useTLS bool
+	user, pass string
+	db int
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// redisError is an error reply from the server (e.g. WRONGTYPE), as opposed to a connection failure.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// default per-command deadline when ctx has none
+const redisTimeout = 5 * time.Second
+
+// newRedisClient parses redis://[user:pass@]host:port/db (rediss:// for TLS).
+func newRedisClient(rawURL string, maxIdle int) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil { return nil, err }
+	rc := &redisClient{addr: u.Host, idle: make(chan *redisConn, maxIdle)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss": rc.useTLS = true
+	default: return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() == "" { rc.addr = net.JoinHostPort(u.Hostname(), "6379") }
+	if u.User != nil {
+		rc.user = u.User.Username()
+		rc.pass, _ = u.User.Password()
+		// redis://:pass@host is the classic password-only form
+		if _, ok := u.User.Password(); !ok { rc.pass, rc.user = rc.user, "" }
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		if rc.db, err = strconv.Atoi(p); err != nil { return nil, fmt.Errorf("bad database %q", p) }
+	}
+	return rc, nil
+}
+
+func (rc *redisClient) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: redisTimeout}
+	var c net.Conn
+	var err error
+	if rc.useTLS {
+		td := tls.Dialer{NetDialer: &d}
+		c, err = td.DialContext(ctx, "tcp", rc.addr)
+	} else {
+		c, err = d.DialContext(ctx, "tcp", rc.addr)
+	}
+	if err != nil { return nil, err }
+	cn := &redisConn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	var setup [][]any
+	if rc.pass != "" {
+		if rc.user != "" { setup = append(setup, []any{"AUTH", rc.user, rc.pass}) } else { setup = append(setup, []any{"AUTH", rc.pass}) }
+	}
+	if rc.db != 0 { setup = append(setup, []any{"SELECT", rc.db}) }
+	for _, args := range setup {
+		if _, err := cn.do(ctx, args...); err != nil { c.Close(); return nil, err }
+	}
+	return cn, nil
+}
+
+// do runs one command and returns the reply: string, int64, []byte (nil for
+// a null bulk) or []any. Error replies come back as a redisError.
+func (rc *redisClient) do(ctx context.Context, args ...any) (any, error) {
+	var cn *redisConn
+	select {
+	case cn = <-rc.idle:
+	default:
+		var err error
+		if cn, err = rc.dial(ctx); err != nil { return nil, err }
+	}
+	v, err := cn.do(ctx, args...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		cn.c.Close()
+		return nil, err
+	}
+	select {
+	case rc.idle <- cn:
+	default:
+		cn.c.Close()
+	}
+	return v, err
+}
+
+func (cn *redisConn) do(ctx context.Context, args ...any) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok { deadline = time.Now().Add(redisTimeout) }
+	_ = cn.c.SetDeadline(deadline)
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		var b []byte
+		switch v := a.(type) {
+		case []byte: b = v
+		case string: b = []byte(v)
+		case int: b = strconv.AppendInt(nil, int64(v), 10)
+		case int64: b = strconv.AppendInt(nil, v, 10)
+		default: return nil, fmt.Errorf("redis: unsupported argument type %T", a)
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n", len(b))
+		cn.w.Write(b)
+		cn.w.WriteString("\r\n")
+	}
+	if err := cn.w.Flush(); err != nil { return nil, err }
+	return cn.read()
+}
+
+func (cn *redisConn) read() (any, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil { return nil, err }
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") { return nil, fmt.Errorf("redis: malformed reply %q", line) }
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil { return nil, err }
+		if n < 0 { return []byte(nil), nil }
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil { return nil, err }
+		if b[n] != '\r' || b[n+1] != '\n' { return nil, fmt.Errorf("redis: bulk reply of %d bytes not followed by CRLF", n) }
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil { return nil, err }
+		if n < 0 { return []any(nil), nil }
+		out := make([]any, n)
+		for i := range out {
+			// keep reading past error elements so the connection stays in sync
+			v, err := cn.read()
+			var re redisError
+			if errors.As(err, &re) { v, err = re, nil }
+			if err != nil { return nil, err }
+			out[i] = v
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
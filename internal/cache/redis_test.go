@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gh-proxy/internal/config"
+)
+
+func readReply(raw string) (any, error) {
+	cn := &redisConn{r: bufio.NewReader(strings.NewReader(raw))}
+	return cn.read()
+}
+
+func TestRedisRead(t *testing.T) {
+	cases := []struct {
+		raw string
+		want any
+	}{
+		{"+OK\r\n", "OK"},
+		{"+\r\n", ""},
+		{":42\r\n", int64(42)},
+		{":-1\r\n", int64(-1)},
+		{"$5\r\nhello\r\n", []byte("hello")},
+		{"$0\r\n\r\n", []byte{}},
+		{"$4\r\na\r\nb\r\n", []byte("a\r\nb")}, // bulk strings are binary safe
+		{"$-1\r\n", []byte(nil)},
+		{"*0\r\n", []any{}},
+		{"*-1\r\n", []any(nil)},
+		{"*3\r\n$3\r\nfoo\r\n:7\r\n$-1\r\n", []any{[]byte("foo"), int64(7), []byte(nil)}},
+		{"*2\r\n*1\r\n+a\r\n*2\r\n:1\r\n:2\r\n", []any{[]any{"a"}, []any{int64(1), int64(2)}}},
+		// an error inside an array (e.g. from EXEC) is an element, not a failed read
+		{"*2\r\n+OK\r\n-WRONGTYPE bad\r\n", []any{"OK", redisError("WRONGTYPE bad")}},
+	}
+	for _, c := range cases {
+		got, err := readReply(c.raw)
+		if err != nil { t.Errorf("read(%q): %v", c.raw, err); continue }
+		if !reflect.DeepEqual(got, c.want) { t.Errorf("read(%q) = %#v, want %#v", c.raw, got, c.want) }
+	}
+}
+
+func TestRedisReadErrorReply(t *testing.T) {
+	_, err := readReply("-ERR unknown command\r\n")
+	var re redisError
+	if !errors.As(err, &re) || string(re) != "ERR unknown command" { t.Fatalf("got %v, want redisError", err) }
+}
+
+func TestRedisReadMalformed(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"+OK\n",
+		"OK\r\n",
+		"?x\r\n",
+		":notanumber\r\n",
+		"$x\r\n",
+		"$5\r\nhel",
+		"$3\r\nabcde\r\n",
+		"*2\r\n+OK\r\n",
+		"*x\r\n",
+	} {
+		_, err := readReply(raw)
+		var re redisError
+		if err == nil || errors.As(err, &re) { t.Errorf("read(%q) = %v, want a protocol error", raw, err) }
+	}
+}
+
+// the next reply must be readable after each one, so pipelined connections stay in sync
+func TestRedisReadSequence(t *testing.T) {
+	cn := &redisConn{r: bufio.NewReader(strings.NewReader("$3\r\nabc\r\n*2\r\n-ERR x\r\n:1\r\n+PONG\r\n"))}
+	for i, want := range []any{[]byte("abc"), []any{redisError("ERR x"), int64(1)}, "PONG"} {
+		got, err := cn.read()
+		if err != nil || !reflect.DeepEqual(got, want) { t.Fatalf("reply %d = %#v, %v; want %#v", i+1, got, err, want) }
+	}
+}
+
+// TestRedisBackendContract runs against the server at REDIS_URL when set.
+// It deletes every cache entry (ghproxy:cache:*) on that server first.
+func TestRedisBackendContract(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" { t.Skip("REDIS_URL not set") }
+	b, err := NewRedisBackend(config.Config{RedisURL: url})
+	if err != nil { t.Fatal(err) }
+	if _, err := b.Delete(context.Background(), Match{}); err != nil { t.Fatal(err) }
+	testBackendContract(t, b)
+}
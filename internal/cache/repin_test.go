@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"gh-proxy/internal/config"
+	"gh-proxy/internal/db/dbtest"
+)
+
+// repinCache is a Cache on a PostgresBackend whose cached_responses holds rows.
+func repinCache(pins []string, rows ...[]any) (*Cache, *dbtest.DB) {
+	d := dbtest.New()
+	d.On("SELECT id, url, pinned FROM cached_responses", rows...)
+	cfg := config.Config{CachePinPatterns: pins}
+	return NewWithBackend(NewPostgresBackend(d, cfg), cfg), d
+}
+
+func TestRepin(t *testing.T) {
+	c, d := repinCache([]string{"/repos/hackclub/*"},
+		[]any{int64(1), "https://api.github.com/repos/hackclub/a", false},
+		[]any{int64(2), "https://api.github.com/repos/hackclub/b", true},
+		[]any{int64(3), "https://api.github.com/repos/other/c", true},
+		[]any{int64(4), "https://api.github.com/repos/other/d", false},
+		[]any{int64(5), "https://api.github.com/repos/hackclub/e/issues", true},
+	)
+	c.repin(context.Background())
+	got := map[string]bool{}
+	for _, e := range d.Execs() { got[fmt.Sprint(e.Args...)] = true }
+	if len(got) != 2 || !got["[1] true"] || !got["[3 5] false"] { t.Fatalf("updates = %v, want rows 1 pinned and 3, 5 unpinned", d.Execs()) }
+	if !c.repinned { t.Fatal("a short batch didn't finish the pass") }
+	// once done, later cleanups leave the table alone
+	c.repin(context.Background())
+	if n := len(d.Execs()); n != 2 { t.Fatalf("%d updates after the pass finished", n) }
+}
+
+func TestRepinNoPatterns(t *testing.T) {
+	c, d := repinCache(nil, []any{int64(1), "https://api.github.com/repos/hackclub/a", true})
+	c.repin(context.Background())
+	// without patterns nothing needs matching: every pin is dropped in one statement
+	if ex := d.Execs(); len(ex) != 1 || ex[0].SQL != `UPDATE cached_responses SET pinned=false WHERE pinned` { t.Fatalf("execs = %v", ex) }
+	if !c.repinned { t.Fatal("pass not finished") }
+}
+
+func TestRepinBatches(t *testing.T) {
+	// a full batch means there may be more rows: the pass resumes after the last id
+	rows := make([][]any, repinBatch)
+	for i := range rows { rows[i] = []any{int64(i + 1), "https://api.github.com/users/u", false} }
+	c, _ := repinCache([]string{"/users/*"}, rows...)
+	pg := c.backend.(*PostgresBackend)
+	done, err := pg.Repin(context.Background(), c.pinned)
+	if err != nil || done { t.Fatalf("done %v, err %v; want an unfinished pass", done, err) }
+	if pg.repinAfter != repinBatch { t.Fatalf("resumes after %d, want %d", pg.repinAfter, repinBatch) }
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Size accounting: every row records its logical stored_bytes (headers, plus
+// the body for legacy inline rows), every blob its size, and the cache keeps a
+// running total of both, so eviction targets what is actually stored rather
+// than pg_total_relation_size, which only shrinks after VACUUM.
+
+// re-read SUM(stored_bytes) this often to absorb other instances' writes
+const usageResyncInterval = 10 * time.Minute
+
+// evict this many rows per DELETE while above the low watermark
+const evictBatch = 500
+
+func storedBytes(headers, body []byte) int64 { return int64(len(headers) + len(body)) }
+
+// syncUsage reloads the byte counter from the table when it is due.
+func (pg *PostgresBackend) syncUsage(ctx context.Context, force bool) error {
+	if !force && time.Since(pg.usageSyncedAt) < usageResyncInterval { return nil }
+	var total int64
+	if err := pg.pool.QueryRow(ctx, `SELECT (SELECT COALESCE(SUM(stored_bytes),0) FROM cached_responses) + (SELECT COALESCE(SUM(size),0) FROM cache_blobs)`).Scan(&total); err != nil { return err }
+	pg.usedBytes.Store(total)
+	pg.usageSyncedAt = time.Now()
+	return nil
+}
+
+// backfillStoredBytes measures a batch of rows cached before stored_bytes
+// existed (NULL), so upgrading doesn't rewrite the whole table at startup.
+func (pg *PostgresBackend) backfillStoredBytes(ctx context.Context) error {
+	var n, added int64
+	err := pg.pool.QueryRow(ctx, `WITH u AS (UPDATE cached_responses SET stored_bytes=octet_length(resp_headers::text)+COALESCE(octet_length(resp_body),0) WHERE id IN (SELECT id FROM cached_responses WHERE stored_bytes IS NULL ORDER BY id LIMIT 500) RETURNING stored_bytes) SELECT count(*), COALESCE(SUM(stored_bytes),0)::bigint FROM u`).Scan(&n, &added)
+	if err != nil { return err }
+	pg.usedBytes.Add(added)
+	if n > 0 { log.Printf("cache: measured %d rows cached before size tracking", n) }
+	return nil
+}
+
+// deleteWhere deletes matching rows, then any blobs only they referenced, and
+// takes the bytes of both off the counter.
+func (pg *PostgresBackend) deleteWhere(ctx context.Context, where string, args ...any) (int64, error) {
+	var n, freed int64
+	var hashes []string
+	err := pg.pool.QueryRow(ctx, `WITH d AS (DELETE FROM cached_responses WHERE `+where+` RETURNING stored_bytes, body_hash) SELECT count(*), COALESCE(SUM(stored_bytes),0)::bigint, COALESCE(array_agg(DISTINCT body_hash) FILTER (WHERE body_hash IS NOT NULL), '{}') FROM d`, args...).Scan(&n, &freed, &hashes)
+	if err != nil { return 0, err }
+	pg.usedBytes.Add(-freed)
+	if _, err := pg.sweepBlobs(ctx, hashes); err != nil { return n, err }
+	return n, nil
+}
+
+// evict deletes unpinned rows in eviction order until usage is under the low
+// watermark. It only starts once usage passes the high watermark.
+func (pg *PostgresBackend) evict(ctx context.Context) error {
+	high := pg.maxSizeMB * 1024 * 1024
+	low := high * pg.lowWatermarkPct / 100
+	if pg.usedBytes.Load() <= high { return nil }
+	start := pg.usedBytes.Load()
+	var total int64
+	for pg.usedBytes.Load() > low {
+		n, err := pg.deleteWhere(ctx, `id IN (SELECT id FROM cached_responses WHERE pinned=false ORDER BY `+pg.evictionOrder()+` LIMIT $1)`, evictBatch)
+		if err != nil { return err }
+		total += n
+		if n == 0 { break } // only pinned rows left
+	}
+	log.Printf("cache: evicted %d rows by %s (%d MB -> %d MB)", total, pg.eviction, start/1024/1024, pg.usedBytes.Load()/1024/1024)
+	return nil
+}
+
+// Reclaim returns space freed by evictions to the OS. Deleted rows only become
+// reusable after VACUUM, so this runs on its own, slower schedule.
+func (pg *PostgresBackend) Reclaim(ctx context.Context) error {
+	if _, err := pg.pool.Exec(ctx, `VACUUM (ANALYZE) cached_responses`); err != nil { return err }
+	_, err := pg.pool.Exec(ctx, `VACUUM (ANALYZE) cache_blobs`)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -15,12 +16,34 @@ type Config struct {
 	AdminPass         string
 	GithubClientID    string
 	GithubClientSecret string
+	GithubAPIURL      string // REST API root, e.g. https://ghe.example.com/api/v3 for GitHub Enterprise Server
+	GithubWebURL      string // web root for OAuth and profile links
 	MaxCacheTime      timeDuration
+	StaleWhileRevalidate timeDuration // serve expired entries this long past expiry while refreshing in background
+	StaleIfError      timeDuration // serve expired entries this long past expiry when GitHub fails
+	NegativeCacheTime timeDuration // TTL for cached 404/410 responses (0 = don't cache them)
+	NegativeCache451  bool // also negatively cache 451 Unavailable For Legal Reasons
 	MaxCacheSizeMB    int64
+	CacheRulesFile    string // JSON rule table of per-endpoint TTLs (optional)
+	CacheMemoryMB     int64 // in-process L1 cache in front of the cache backend (0 = off)
+	CacheCompression  string // "gzip" or "none" for stored response bodies
+	CacheGraphQL      bool // cache read-only GraphQL queries (opt-in)
+	CacheEviction     string // "lru", "lfu" or "fifo"
+	CachePinPatterns  []string // path patterns never evicted, e.g. /orgs/hackclub/repos
+	CacheLowWatermarkPct int64 // evict down to this % of MAX_CACHE_SIZE_MB once it is exceeded
+	CacheVacuumInterval timeDuration // how often to VACUUM cached_responses (0 = never)
+	CacheBackend      string // where cached responses live: "postgres", "memory" or "redis"
+	RedisURL          string // redis://[user:pass@]host:port/db for CACHE_BACKEND=redis
 	DBMaxConns        int32
 	DBMaxIdleConns    int32
 	DBConnMaxLifetime int32
 	MaxProxyBodyBytes int64
+	MaxCacheableBytes int64 // larger responses are streamed through without being cached (0 = never cache)
+	WarmMinBudgetPct  int64 // cache warmer only runs while this % of a category's token budget remains
+	WarmRequestDelayMS int64 // pause between warmer requests
+	TokenMaxConcurrent int64 // in-flight upstream requests per donated token (0 = unlimited)
+	TokenPointsPerMinute int64 // secondary rate limit budget per token: GET = 1 point, writes = 5 (0 = unlimited)
+	TokenGraphQLPointsPerMinute int64 // the same for GraphQL, which GitHub limits separately
 }
 
 type timeDuration struct{ Seconds int64 }
@@ -44,12 +67,34 @@ func Load() Config {
 		AdminPass:          getenv("ADMIN_PASS", "admin"),
 		GithubClientID:     os.Getenv("GITHUB_OAUTH_CLIENT_ID"),
 		GithubClientSecret: os.Getenv("GITHUB_OAUTH_CLIENT_SECRET"),
+		GithubAPIURL:       strings.TrimRight(getenv("GITHUB_API_URL", "https://api.github.com"), "/"),
+		GithubWebURL:       strings.TrimRight(getenv("GITHUB_WEB_URL", "https://github.com"), "/"),
 		MaxCacheTime:       timeDuration{Seconds: maxCacheTime},
+		StaleWhileRevalidate: timeDuration{Seconds: parseInt(getenv("STALE_WHILE_REVALIDATE", "0"))},
+		StaleIfError:       timeDuration{Seconds: parseInt(getenv("STALE_IF_ERROR", "600"))}, // 10 minutes
+		NegativeCacheTime:  timeDuration{Seconds: parseInt(getenv("NEGATIVE_CACHE_TIME", "60"))},
+		NegativeCache451:   parseBool(getenv("NEGATIVE_CACHE_451", "false")),
 		MaxCacheSizeMB:     maxCacheSize,
+		CacheRulesFile:     os.Getenv("CACHE_RULES_FILE"),
+		CacheMemoryMB:      parseInt(getenv("CACHE_MEMORY_MB", "64")),
+		CacheCompression:   getenv("CACHE_COMPRESSION", "gzip"),
+		CacheGraphQL:       parseBool(getenv("CACHE_GRAPHQL", "false")),
+		CacheEviction:      getenv("CACHE_EVICTION", "lru"),
+		CachePinPatterns:   parseList(os.Getenv("CACHE_PIN_PATTERNS")),
+		CacheLowWatermarkPct: parseInt(getenv("CACHE_LOW_WATERMARK_PCT", "80")),
+		CacheVacuumInterval: timeDuration{Seconds: parseInt(getenv("CACHE_VACUUM_INTERVAL", "3600"))}, // hourly
+		CacheBackend:       getenv("CACHE_BACKEND", "postgres"),
+		RedisURL:           getenv("REDIS_URL", "redis://localhost:6379/0"),
 		DBMaxConns:         parseInt32(getenv("DB_MAX_CONNS", "150")),
 		DBMaxIdleConns:     parseInt32(getenv("DB_MAX_IDLE_CONNS", "50")),
 		DBConnMaxLifetime:  parseInt32(getenv("DB_CONN_MAX_LIFETIME", "1800")), // 30 minutes
 		MaxProxyBodyBytes:  parseInt(getenv("MAX_PROXY_BODY_BYTES", "1048576")), // 1MB
+		MaxCacheableBytes:  parseInt(getenv("MAX_CACHEABLE_BYTES", "10485760")), // 10MB
+		WarmMinBudgetPct:   parseInt(getenv("WARM_MIN_BUDGET_PCT", "50")),
+		WarmRequestDelayMS: parseInt(getenv("WARM_REQUEST_DELAY_MS", "500")),
+		TokenMaxConcurrent: parseInt(getenv("TOKEN_MAX_CONCURRENT", "10")),
+		TokenPointsPerMinute: parseInt(getenv("TOKEN_POINTS_PER_MINUTE", "900")),
+		TokenGraphQLPointsPerMinute: parseInt(getenv("TOKEN_GRAPHQL_POINTS_PER_MINUTE", "2000")),
 	}
 	if cfg.GithubClientID == "" || cfg.GithubClientSecret == "" {
 		log.Println("warning: GitHub OAuth env vars not set; donating tokens won't work")
@@ -57,6 +102,13 @@ func Load() Config {
 	return cfg
 }
 
+// GithubGraphQLURL is the GraphQL endpoint next to GithubAPIURL: /graphql on
+// github.com, /api/graphql on GitHub Enterprise Server (whose REST root is /api/v3).
+func (c Config) GithubGraphQLURL() string {
+	if base, ok := strings.CutSuffix(c.GithubAPIURL, "/api/v3"); ok { return base + "/api/graphql" }
+	return c.GithubAPIURL + "/graphql"
+}
+
 func parseInt(s string) int64 {
 	v, err := strconv.ParseInt(s, 10, 64)
 	if err != nil { return 0 }
@@ -69,6 +121,21 @@ func parseInt32(s string) int32 {
 	return int32(v)
 }
 
+func parseBool(s string) bool {
+	v, err := strconv.ParseBool(s)
+	if err != nil { return false }
+	return v
+}
+
+// parseList splits a comma-separated value, dropping blanks.
+func parseList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" { out = append(out, v) }
+	}
+	return out
+}
+
 func loadDotenv() error {
 	// Try to load .env explicitly; ignore errors if missing
 	_ = godotenv.Load(".env")
@@ -0,0 +1,126 @@
+// Package dbtest is an in-memory stand-in for Postgres in tests. Queries are
+// answered with canned rows picked by a fragment of their SQL (anything else
+// has no rows), and every Exec succeeds and is recorded.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type DB struct {
+	mu sync.Mutex
+	canned []canned
+	execs []Exec
+}
+
+// Exec is one recorded DB.Exec call.
+type Exec struct {
+	SQL string
+	Args []any
+}
+
+type canned struct {
+	sql string
+	rows [][]any
+}
+
+func New() *DB { return &DB{} }
+
+// On answers queries whose SQL contains sqlPart with rows; QueryRow gets the
+// first one. Values are assigned to Scan destinations of the same type (or a
+// pointer to it); nil leaves a destination untouched, and so do missing
+// trailing values. Later calls win over earlier ones.
+func (d *DB) On(sqlPart string, rows ...[]any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.canned = append(d.canned, canned{sqlPart, rows})
+}
+
+// Execs returns every Exec so far.
+func (d *DB) Execs() []Exec {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Exec(nil), d.execs...)
+}
+
+// Executed reports whether an Exec's SQL contained sqlPart.
+func (d *DB) Executed(sqlPart string) bool {
+	for _, e := range d.Execs() {
+		if strings.Contains(e.SQL, sqlPart) { return true }
+	}
+	return false
+}
+
+func (d *DB) lookup(sql string) [][]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := len(d.canned) - 1; i >= 0; i-- {
+		if strings.Contains(sql, d.canned[i].sql) { return d.canned[i].rows }
+	}
+	return nil
+}
+
+func (d *DB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.execs = append(d.execs, Exec{sql, args})
+	return pgconn.NewCommandTag(""), nil
+}
+
+func (d *DB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return &rows{rows: d.lookup(sql)}, nil
+}
+
+func (d *DB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	r := d.lookup(sql)
+	if len(r) == 0 { return row(nil) }
+	return row(r[0])
+}
+
+type row []any
+
+func (r row) Scan(dest ...any) error {
+	if r == nil { return pgx.ErrNoRows }
+	return scan(r, dest)
+}
+
+type rows struct {
+	rows [][]any
+	i int
+}
+
+func (r *rows) Next() bool { r.i++; return r.i <= len(r.rows) }
+func (r *rows) Scan(dest ...any) error { return scan(r.rows[r.i-1], dest) }
+func (r *rows) Values() ([]any, error) { return r.rows[r.i-1], nil }
+func (r *rows) Close() {}
+func (r *rows) Err() error { return nil }
+func (r *rows) CommandTag() pgconn.CommandTag { return pgconn.NewCommandTag("") }
+func (r *rows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *rows) RawValues() [][]byte { return nil }
+func (r *rows) Conn() *pgx.Conn { return nil }
+
+func scan(vals []any, dest []any) error {
+	for i, d := range dest {
+		if i >= len(vals) { break }
+		if vals[i] == nil { continue }
+		dv, v := reflect.ValueOf(d).Elem(), reflect.ValueOf(vals[i])
+		switch {
+		case v.Type().AssignableTo(dv.Type()):
+			dv.Set(v)
+		case dv.Kind() == reflect.Pointer && v.Type().AssignableTo(dv.Type().Elem()):
+			p := reflect.New(dv.Type().Elem())
+			p.Elem().Set(v)
+			dv.Set(p)
+		default:
+			return fmt.Errorf("dbtest: can't scan %T into %T", vals[i], d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+-- Record which cache TTL rule matched each proxied request (shown in admin recent activity)
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS cache_rule TEXT;
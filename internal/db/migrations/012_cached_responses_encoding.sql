@@ -0,0 +1,3 @@
+-- Cached bodies may be stored compressed; existing rows are plain (identity)
+-- and get recompressed in batches by the cache janitor.
+ALTER TABLE cached_responses ADD COLUMN IF NOT EXISTS resp_encoding TEXT NOT NULL DEFAULT 'identity';
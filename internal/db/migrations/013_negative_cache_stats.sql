@@ -0,0 +1,2 @@
+-- Count cache hits served from negatively cached 404/410/451 responses
+ALTER TABLE system_stats ADD COLUMN IF NOT EXISTS total_negative_cached_requests BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Optional extra permissions per API key (e.g. 'purge' for the cache purge endpoint)
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS permissions TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,9 @@
+-- Access recency/frequency for LRU/LFU eviction (updated in batches, not per hit)
+ALTER TABLE cached_responses ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE cached_responses ADD COLUMN IF NOT EXISTS hit_count BIGINT NOT NULL DEFAULT 0;
+
+-- Pinned rows (CACHE_PIN_PATTERNS) are never evicted
+ALTER TABLE cached_responses ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_cached_responses_lru ON cached_responses(last_accessed_at) WHERE pinned = false;
+CREATE INDEX IF NOT EXISTS idx_cached_responses_lfu ON cached_responses(hit_count, last_accessed_at) WHERE pinned = false
@@ -0,0 +1,3 @@
+-- Logical stored size per row (body + headers) for exact MAX_CACHE_SIZE_MB accounting.
+-- Existing rows start NULL and are measured in batches by cache cleanup.
+ALTER TABLE cached_responses ADD COLUMN IF NOT EXISTS stored_bytes BIGINT
@@ -0,0 +1,12 @@
+-- Cache pre-warming jobs registered from /admin
+CREATE TABLE IF NOT EXISTS warm_jobs (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  paths TEXT NOT NULL, -- newline-separated API paths, may contain a {placeholder}
+  vals TEXT NOT NULL DEFAULT '', -- newline-separated values substituted for the placeholder
+  interval_seconds INT NOT NULL DEFAULT 3600,
+  enabled BOOLEAN NOT NULL DEFAULT true,
+  last_run_at TIMESTAMPTZ,
+  last_status TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)
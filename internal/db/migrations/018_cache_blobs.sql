@@ -0,0 +1,17 @@
+-- Content-addressed response bodies: identical bodies are stored once and
+-- referenced from cached_responses by the SHA-256 of the raw (decoded) body.
+-- Existing rows keep resp_body inline until the cache janitor moves them.
+CREATE TABLE IF NOT EXISTS cache_blobs (
+  hash TEXT PRIMARY KEY,
+  body BYTEA NOT NULL,
+  encoding TEXT NOT NULL DEFAULT 'identity',
+  size BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE cached_responses ADD COLUMN IF NOT EXISTS body_hash TEXT;
+ALTER TABLE cached_responses ALTER COLUMN resp_body DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_cached_responses_body_hash ON cached_responses(body_hash);
+CREATE INDEX IF NOT EXISTS idx_cached_responses_legacy_body ON cached_responses(id) WHERE body_hash IS NULL
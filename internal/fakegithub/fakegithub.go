@@ -0,0 +1,447 @@
+// Package fakegithub is a small stand-in for the GitHub API, for running the
+// proxy end to end without real donated tokens. It serves /rate_limit, a few
+// REST endpoints, /graphql, the OAuth web flow and /user, keeps a rate limit
+// budget per token and category, answers conditional requests with 304s, and
+// can be told to fail a token's next requests with 401, 403 or a secondary
+// rate limit (see the /_fake/ control endpoints).
+//
+// Point the proxy at it with GITHUB_API_URL and GITHUB_WEB_URL both set to the
+// fake's address.
+package fakegithub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gh "gh-proxy/internal/github"
+)
+
+// Failure modes for Fail.
+const (
+	FailUnauthorized = "unauthorized" // 401 Bad credentials
+	FailForbidden    = "forbidden"    // 403 without rate limit headers at 0
+	FailPrimary      = "primary"      // 403 with X-RateLimit-Remaining: 0
+	FailSecondary    = "secondary"    // 403 secondary rate limit with Retry-After
+)
+
+// how long a secondary rate limit asks the client to wait
+const secondaryRetryAfter = 60
+
+type Server struct {
+	mu sync.Mutex
+	limits map[string]int // per category; categories not listed get core's
+	window time.Duration
+	tokens map[string]*token
+	nextID int
+	mux *http.ServeMux
+}
+
+type token struct {
+	login string
+	revoked bool
+	used map[string]int
+	reset map[string]time.Time
+	requests int
+	fail string
+	failCount int
+}
+
+// New returns a fake whose tokens get coreLimit requests (GitHub's search
+// and GraphQL defaults for the other buckets) per window.
+func New(coreLimit int, window time.Duration) *Server {
+	s := &Server{
+		limits: map[string]int{"core": coreLimit, "search": 30, "code_search": 10, "graphql": coreLimit},
+		window: window,
+		tokens: map[string]*token{},
+		mux: http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /rate_limit", s.handleRateLimit)
+	s.mux.HandleFunc("GET /user", s.api(s.handleUser))
+	s.mux.HandleFunc("GET /users/{login}", s.api(s.handleUsers))
+	s.mux.HandleFunc("GET /repos/{owner}/{repo}", s.api(s.handleRepo))
+	s.mux.HandleFunc("GET /repos/{owner}/{repo}/issues", s.api(s.handleIssues))
+	s.mux.HandleFunc("GET /search/repositories", s.api(s.handleSearch))
+	s.mux.HandleFunc("GET /search/code", s.api(s.handleSearch))
+	s.mux.HandleFunc("POST /graphql", s.api(s.handleGraphQL))
+	s.mux.HandleFunc("GET /login/oauth/authorize", s.handleAuthorize)
+	s.mux.HandleFunc("POST /login/oauth/access_token", s.handleAccessToken)
+	s.mux.HandleFunc("POST /_fake/tokens", s.handleAddToken)
+	s.mux.HandleFunc("POST /_fake/fail", s.handleFail)
+	s.mux.HandleFunc("POST /_fake/reset", s.handleReset)
+	s.mux.HandleFunc("GET /_fake/stats", s.handleStats)
+	s.mux.HandleFunc("/", s.api(func(w http.ResponseWriter, r *http.Request, _ *token) { notFound(w) }))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+// AddToken registers a token for login; unknown tokens are accepted too and get a generated login.
+func (s *Server) AddToken(tok, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.token(tok)
+	t.login, t.revoked = login, false
+}
+
+// Revoke makes every later request with tok fail with 401.
+func (s *Server) Revoke(tok string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token(tok).revoked = true
+}
+
+// Fail makes the next n requests with tok fail in the given mode.
+func (s *Server) Fail(tok, mode string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.token(tok)
+	t.fail, t.failCount = mode, n
+}
+
+// SetRemaining sets how many requests tok has left in category this window.
+func (s *Server) SetRemaining(tok, category string, remaining int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.token(tok)
+	s.roll(t, category)
+	t.used[category] = max(0, s.limit(category)-remaining)
+}
+
+// Requests reports how many API requests tok has made, including failed ones.
+func (s *Server) Requests(tok string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tokens[tok]; ok { return t.requests }
+	return 0
+}
+
+// Reset forgets every token.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]*token{}
+}
+
+// token returns the state for tok, creating it on first sight. Callers hold mu.
+func (s *Server) token(tok string) *token {
+	t, ok := s.tokens[tok]
+	if !ok {
+		s.nextID++
+		t = &token{login: fmt.Sprintf("fake-user-%d", s.nextID), used: map[string]int{}, reset: map[string]time.Time{}}
+		s.tokens[tok] = t
+	}
+	return t
+}
+
+func (s *Server) limit(category string) int {
+	if l, ok := s.limits[category]; ok { return l }
+	return s.limits["core"]
+}
+
+// roll starts a new window for category once the previous one has passed.
+func (s *Server) roll(t *token, category string) {
+	if now := time.Now(); !t.reset[category].After(now) {
+		t.used[category] = 0
+		t.reset[category] = now.Add(s.window).Truncate(time.Second)
+	}
+}
+
+func (s *Server) setRateHeaders(w http.ResponseWriter, t *token, category string) {
+	h := w.Header()
+	limit := s.limit(category)
+	h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(max(0, limit-t.used[category])))
+	h.Set("X-RateLimit-Used", strconv.Itoa(t.used[category]))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(t.reset[category].Unix(), 10))
+	h.Set("X-RateLimit-Resource", category)
+}
+
+func bearer(r *http.Request) string {
+	a := r.Header.Get("Authorization")
+	if tok, ok := strings.CutPrefix(a, "Bearer "); ok { return tok }
+	if tok, ok := strings.CutPrefix(a, "token "); ok { return tok }
+	return ""
+}
+
+// api wraps an endpoint with authentication, rate limiting, injected failures
+// and conditional request handling. Like GitHub, a 304 costs nothing.
+func (s *Server) api(h func(w http.ResponseWriter, r *http.Request, t *token)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tok := bearer(r)
+		if tok == "" { writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Requires authentication"}); return }
+		s.mu.Lock()
+		t := s.token(tok)
+		t.requests++
+		category := gh.CategoryFor(r.URL.Path)
+		s.roll(t, category)
+		if t.revoked {
+			s.mu.Unlock()
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		}
+		fail := ""
+		if t.failCount > 0 {
+			fail = t.fail
+			t.failCount--
+		}
+		if fail == FailPrimary || t.used[category] >= s.limit(category) {
+			// an injected failure only reports the budget as spent; the real one is untouched
+			s.setRateHeaders(w, t, category)
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Used", strconv.Itoa(s.limit(category)))
+			s.mu.Unlock()
+			writeJSON(w, http.StatusForbidden, map[string]string{"message": "API rate limit exceeded for user ID 1."})
+			return
+		}
+		t.used[category]++
+		s.setRateHeaders(w, t, category)
+		s.mu.Unlock()
+		switch fail {
+		case FailUnauthorized:
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+			return
+		case FailForbidden:
+			writeJSON(w, http.StatusForbidden, map[string]string{"message": "Resource not accessible by personal access token"})
+			return
+		case FailSecondary:
+			w.Header().Set("Retry-After", strconv.Itoa(secondaryRetryAfter))
+			writeJSON(w, http.StatusForbidden, map[string]string{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."})
+			return
+		}
+		rec := &recorder{header: http.Header{}}
+		h(rec, r, t)
+		s.finish(w, r, t, category, rec)
+	}
+}
+
+// recorder buffers an endpoint's response so an ETag can be computed over it.
+type recorder struct {
+	header http.Header
+	status int
+	body []byte
+}
+
+func (rec *recorder) Header() http.Header { return rec.header }
+func (rec *recorder) Write(b []byte) (int, error) { rec.body = append(rec.body, b...); return len(b), nil }
+func (rec *recorder) WriteHeader(status int) { rec.status = status }
+
+func (s *Server) finish(w http.ResponseWriter, r *http.Request, t *token, category string, rec *recorder) {
+	for k, v := range rec.header { w.Header()[k] = v }
+	if rec.status == 0 { rec.status = http.StatusOK }
+	if rec.status == http.StatusOK && r.Method == http.MethodGet {
+		sum := sha256.Sum256(rec.body)
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, max-age=60, s-maxage=60")
+		if r.Header.Get("If-None-Match") == etag {
+			// conditional hits are free: give back the request charged above
+			s.mu.Lock()
+			t.used[category]--
+			s.setRateHeaders(w, t, category)
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(rec.status)
+	_, _ = w.Write(rec.body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found", "documentation_url": "https://docs.github.com/rest"})
+}
+
+func (s *Server) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	tok := bearer(r)
+	if tok == "" { writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Requires authentication"}); return }
+	s.mu.Lock()
+	t := s.token(tok)
+	if t.revoked {
+		s.mu.Unlock()
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+		return
+	}
+	type resource struct {
+		Limit int `json:"limit"`
+		Used int `json:"used"`
+		Remaining int `json:"remaining"`
+		Reset int64 `json:"reset"`
+	}
+	resources := map[string]resource{}
+	for c := range s.limits {
+		s.roll(t, c)
+		resources[c] = resource{s.limit(c), t.used[c], max(0, s.limit(c)-t.used[c]), t.reset[c].Unix()}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"resources": resources, "rate": resources["core"]})
+}
+
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request, t *token) {
+	s.mu.Lock()
+	login := t.login
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"login": login, "id": 1, "type": "User"})
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request, _ *token) {
+	writeJSON(w, http.StatusOK, map[string]any{"login": r.PathValue("login"), "id": 1, "type": "User"})
+}
+
+func (s *Server) handleRepo(w http.ResponseWriter, r *http.Request, _ *token) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id": 1,
+		"name": repo,
+		"full_name": owner + "/" + repo,
+		"owner": map[string]any{"login": owner},
+		"private": false,
+		"stargazers_count": 42,
+	})
+}
+
+func (s *Server) handleIssues(w http.ResponseWriter, r *http.Request, _ *token) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 1)
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage <= 0 || perPage > 100 { perPage = 30 }
+	const total = 250
+	var issues []map[string]any
+	for n := (page-1)*perPage + 1; n <= min(page*perPage, total); n++ {
+		issues = append(issues, map[string]any{"number": n, "title": fmt.Sprintf("Issue %d", n), "state": "open"})
+	}
+	if page*perPage < total {
+		next := *r.URL
+		q := next.Query()
+		q.Set("page", strconv.Itoa(page+1))
+		next.RawQuery = q.Encode()
+		w.Header().Set("Link", `<`+next.String()+`>; rel="next"`)
+	}
+	if issues == nil { issues = []map[string]any{} }
+	writeJSON(w, http.StatusOK, issues)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request, _ *token) {
+	writeJSON(w, http.StatusOK, map[string]any{"total_count": 0, "incomplete_results": false, "items": []any{}})
+}
+
+// handleGraphQL answers any query with the viewer and, as GitHub does for
+// queries that select it, the rateLimit block. Every query costs 1 point.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request, t *token) {
+	var req struct{ Query string `json:"query"` }
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &req); err != nil || req.Query == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+	s.mu.Lock()
+	login := t.login
+	limit := s.limit("graphql")
+	remaining := max(0, limit-t.used["graphql"])
+	reset := t.reset["graphql"]
+	s.mu.Unlock()
+	data := map[string]any{"viewer": map[string]any{"login": login}}
+	if strings.Contains(req.Query, "rateLimit") {
+		data["rateLimit"] = map[string]any{"cost": 1, "limit": limit, "remaining": remaining, "resetAt": reset.UTC().Format(time.RFC3339)}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": data})
+}
+
+// handleAuthorize skips the consent screen and sends the browser straight back with a code.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	redir, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+	if err != nil || redir.Scheme == "" { http.Error(w, "missing redirect_uri", http.StatusBadRequest); return }
+	q := redir.Query()
+	q.Set("code", fmt.Sprintf("code-%d", time.Now().UnixNano()))
+	q.Set("state", r.URL.Query().Get("state"))
+	redir.RawQuery = q.Encode()
+	http.Redirect(w, r, redir.String(), http.StatusFound)
+}
+
+// handleAccessToken exchanges any code for a new token named after it.
+func (s *Server) handleAccessToken(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	if code == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"error": "bad_verification_code", "error_description": "The code passed is incorrect or expired."})
+		return
+	}
+	tok := "gho_fake_" + code
+	s.mu.Lock()
+	s.token(tok)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": tok, "token_type": "bearer", "scope": "read:user"})
+}
+
+// POST /_fake/tokens?token=...&login=...
+func (s *Server) handleAddToken(w http.ResponseWriter, r *http.Request) {
+	tok := r.FormValue("token")
+	if tok == "" { http.Error(w, "missing token", http.StatusBadRequest); return }
+	login := r.FormValue("login")
+	if login == "" { login = "fake-" + tok }
+	s.AddToken(tok, login)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /_fake/fail?token=...&mode=unauthorized|forbidden|primary|secondary|revoke&count=N
+// (mode=remaining&category=core&count=N sets the budget instead)
+func (s *Server) handleFail(w http.ResponseWriter, r *http.Request) {
+	tok := r.FormValue("token")
+	if tok == "" { http.Error(w, "missing token", http.StatusBadRequest); return }
+	n, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil { n = 1 }
+	switch mode := r.FormValue("mode"); mode {
+	case "revoke":
+		s.Revoke(tok)
+	case "remaining":
+		c := r.FormValue("category")
+		if c == "" { c = "core" }
+		s.SetRemaining(tok, c, n)
+	case FailUnauthorized, FailForbidden, FailPrimary, FailSecondary:
+		s.Fail(tok, mode, n)
+	default:
+		http.Error(w, "unknown mode", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	s.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /_fake/stats: requests and remaining budget per token
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	type stat struct {
+		Login string `json:"login"`
+		Revoked bool `json:"revoked"`
+		Requests int `json:"requests"`
+		Remaining map[string]int `json:"remaining"`
+	}
+	s.mu.Lock()
+	out := map[string]stat{}
+	for tok, t := range s.tokens {
+		st := stat{Login: t.login, Revoked: t.revoked, Requests: t.requests, Remaining: map[string]int{}}
+		for c := range s.limits {
+			s.roll(t, c)
+			st.Remaining[c] = max(0, s.limit(c)-t.used[c])
+		}
+		out[tok] = st
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, out)
+}
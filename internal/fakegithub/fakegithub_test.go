@@ -0,0 +1,77 @@
+package fakegithub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url, tok string, extra http.Header) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	for k, v := range extra { req.Header[k] = v }
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil { t.Fatal(err) }
+	resp.Body.Close()
+	return resp
+}
+
+func TestFailPrimaryOnlyFailsNextRequests(t *testing.T) {
+	fake := New(100, time.Hour)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fake.Fail("t1", FailPrimary, 2)
+	for i := 0; i < 2; i++ {
+		resp := get(t, srv.URL+"/user", "t1", nil)
+		if resp.StatusCode != http.StatusForbidden || resp.Header.Get("X-RateLimit-Remaining") != "0" {
+			t.Fatalf("request %d: got %d remaining %q, want 403 remaining 0", i+1, resp.StatusCode, resp.Header.Get("X-RateLimit-Remaining"))
+		}
+	}
+	resp := get(t, srv.URL+"/user", "t1", nil)
+	if resp.StatusCode != http.StatusOK { t.Fatalf("after the injected failures: got %d, want 200", resp.StatusCode) }
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "99" { t.Fatalf("remaining = %q, want 99", got) }
+}
+
+func TestBudgetRunsOut(t *testing.T) {
+	fake := New(2, time.Hour)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		if resp := get(t, srv.URL+"/user", "t1", nil); resp.StatusCode != http.StatusOK { t.Fatalf("request %d: got %d", i+1, resp.StatusCode) }
+	}
+	if resp := get(t, srv.URL+"/user", "t1", nil); resp.StatusCode != http.StatusForbidden { t.Fatalf("over budget: got %d, want 403", resp.StatusCode) }
+	// other tokens and categories have their own budgets
+	if resp := get(t, srv.URL+"/user", "t2", nil); resp.StatusCode != http.StatusOK { t.Fatalf("other token: got %d", resp.StatusCode) }
+	if resp := get(t, srv.URL+"/search/repositories?q=x", "t1", nil); resp.StatusCode != http.StatusOK { t.Fatalf("search: got %d", resp.StatusCode) }
+}
+
+func TestConditionalRequestIsFree(t *testing.T) {
+	fake := New(100, time.Hour)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	first := get(t, srv.URL+"/repos/o/r", "t1", nil)
+	etag := first.Header.Get("ETag")
+	if etag == "" { t.Fatal("no ETag on 200") }
+	resp := get(t, srv.URL+"/repos/o/r", "t1", http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusNotModified { t.Fatalf("got %d, want 304", resp.StatusCode) }
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "99" { t.Fatalf("remaining after 304 = %q, want 99", got) }
+}
+
+func TestRevokeAndSecondary(t *testing.T) {
+	fake := New(100, time.Hour)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	fake.Fail("t1", FailSecondary, 1)
+	resp := get(t, srv.URL+"/user", "t1", nil)
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("Retry-After") == "" { t.Fatalf("secondary: got %d Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After")) }
+
+	fake.Revoke("t1")
+	if resp := get(t, srv.URL+"/user", "t1", nil); resp.StatusCode != http.StatusUnauthorized { t.Fatalf("revoked: got %d, want 401", resp.StatusCode) }
+	if n := fake.Requests("t1"); n != 2 { t.Fatalf("Requests = %d, want 2", n) }
+}
@@ -0,0 +1,60 @@
+package github
+
+import (
+	"net/url"
+	"strings"
+)
+
+// categoryRules maps API paths to the /rate_limit resource they draw from.
+// Patterns are matched segment by segment against the start of the path;
+// "*" matches any single segment. The first match wins, so specific rules
+// go before broader ones, and anything unmatched is "core".
+var categoryRules = []struct{ pattern, category string }{
+	{"/graphql", "graphql"},
+	{"/search/code", "code_search"},
+	{"/search", "search"},
+	{"/app-manifests/*/conversions", "integration_manifest"},
+	{"/repos/*/*/code-scanning/sarifs", "code_scanning_upload"},
+	{"/repos/*/*/code-scanning/alerts/*/autofix", "code_scanning_autofix"},
+	{"/repos/*/*/actions/runners/registration-token", "actions_runner_registration"},
+	{"/repos/*/*/actions/runners/remove-token", "actions_runner_registration"},
+	{"/orgs/*/actions/runners/registration-token", "actions_runner_registration"},
+	{"/orgs/*/actions/runners/remove-token", "actions_runner_registration"},
+	{"/enterprises/*/actions/runners/registration-token", "actions_runner_registration"},
+	{"/enterprises/*/actions/runners/remove-token", "actions_runner_registration"},
+	{"/repos/*/*/dependency-graph/snapshots", "dependency_snapshots"},
+	{"/repos/*/*/dependency-graph/sbom", "dependency_sbom"},
+	{"/repos/*/*/import", "source_import"},
+	{"/scim/v2", "scim"},
+	{"/enterprises/*/audit-log/streams", "audit_log_streaming"},
+	{"/enterprises/*/audit-log", "audit_log"},
+	{"/orgs/*/audit-log", "audit_log"},
+}
+
+// CategoryFor names the /rate_limit resource a request URL draws from.
+func CategoryFor(rawURL string) string {
+	segs := strings.Split(strings.Trim(APIPath(rawURL), "/"), "/")
+	for _, r := range categoryRules {
+		if matchSegments(strings.Split(strings.Trim(r.pattern, "/"), "/"), segs) { return r.category }
+	}
+	return "core"
+}
+
+// APIPath is the path of an upstream URL relative to the API root, so
+// GitHub Enterprise Server's /api/v3/repos/... and /api/graphql read the same
+// as github.com's /repos/... and /graphql.
+func APIPath(rawURL string) string {
+	p := rawURL
+	if u, err := url.Parse(rawURL); err == nil { p = u.Path }
+	if rest, ok := strings.CutPrefix(p, "/api/v3"); ok && (rest == "" || rest[0] == '/') { return rest }
+	if p == "/api/graphql" { return "/graphql" }
+	return p
+}
+
+func matchSegments(pattern, segs []string) bool {
+	if len(segs) < len(pattern) { return false }
+	for i, p := range pattern {
+		if p != "*" && p != segs[i] { return false }
+	}
+	return true
+}
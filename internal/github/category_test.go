@@ -0,0 +1,81 @@
+package github
+
+import "testing"
+
+func TestCategoryFor(t *testing.T) {
+	cases := []struct{ url, want string }{
+		{"https://api.github.com/repos/hackclub/gh-proxy", "core"},
+		{"https://api.github.com/user", "core"},
+		{"https://api.github.com/", "core"},
+		{"https://api.github.com/graphql", "graphql"},
+		{"https://api.github.com/search/code?q=foo", "code_search"},
+		{"https://api.github.com/search/repositories?q=foo", "search"},
+		{"https://api.github.com/search/issues", "search"},
+		{"https://api.github.com/search", "search"},
+		{"https://api.github.com/app-manifests/abc123/conversions", "integration_manifest"},
+		{"https://api.github.com/repos/o/r/code-scanning/sarifs", "code_scanning_upload"},
+		{"https://api.github.com/repos/o/r/code-scanning/sarifs/42", "code_scanning_upload"},
+		{"https://api.github.com/repos/o/r/code-scanning/alerts/7/autofix", "code_scanning_autofix"},
+		{"https://api.github.com/repos/o/r/actions/runners/registration-token", "actions_runner_registration"},
+		{"https://api.github.com/repos/o/r/actions/runners/remove-token", "actions_runner_registration"},
+		{"https://api.github.com/orgs/o/actions/runners/registration-token", "actions_runner_registration"},
+		{"https://api.github.com/orgs/o/actions/runners/remove-token", "actions_runner_registration"},
+		{"https://api.github.com/enterprises/e/actions/runners/registration-token", "actions_runner_registration"},
+		{"https://api.github.com/enterprises/e/actions/runners/remove-token", "actions_runner_registration"},
+		{"https://api.github.com/repos/o/r/dependency-graph/snapshots", "dependency_snapshots"},
+		{"https://api.github.com/repos/o/r/dependency-graph/sbom", "dependency_sbom"},
+		{"https://api.github.com/repos/o/r/import", "source_import"},
+		{"https://api.github.com/repos/o/r/import/authors", "source_import"},
+		{"https://api.github.com/scim/v2/organizations/o/Users", "scim"},
+		{"https://api.github.com/enterprises/e/audit-log/streams", "audit_log_streaming"},
+		{"https://api.github.com/enterprises/e/audit-log/streams/3", "audit_log_streaming"},
+		{"https://api.github.com/enterprises/e/audit-log", "audit_log"},
+		{"https://api.github.com/orgs/o/audit-log?phrase=x", "audit_log"},
+
+		// GitHub Enterprise Server
+		{"https://ghe.example.com/api/v3/repos/o/r", "core"},
+		{"https://ghe.example.com/api/v3/search/code?q=foo", "code_search"},
+		{"https://ghe.example.com/api/v3/search/issues", "search"},
+		{"https://ghe.example.com/api/v3/repos/o/r/dependency-graph/sbom", "dependency_sbom"},
+		{"https://ghe.example.com/api/v3/orgs/o/audit-log", "audit_log"},
+		{"https://ghe.example.com/api/graphql", "graphql"},
+
+		// bare paths, as the fake sees them
+		{"/search/code", "code_search"},
+		{"/graphql", "graphql"},
+
+		// near misses
+		{"https://api.github.com/searchfoo", "core"},
+		{"https://api.github.com/search-code", "core"},
+		{"https://api.github.com/graphqlx", "core"},
+		{"https://api.github.com/repos/search/code", "core"},
+		{"https://api.github.com/repos/o/r/imports", "core"},
+		{"https://api.github.com/repos/o/import", "core"},
+		{"https://api.github.com/repos/o/r/code-scanning/alerts/7", "core"},
+		{"https://api.github.com/repos/o/r/dependency-graph/compare/a...b", "core"},
+		{"https://api.github.com/orgs/o/actions/runners", "core"},
+		{"https://api.github.com/scim/v1/x", "core"},
+		{"https://api.github.com/users/o/audit-log", "core"},
+		{"https://ghe.example.com/api/v30/search/code", "core"},
+		{"https://ghe.example.com/api/graphql/x", "core"},
+		{"https://ghe.example.com/api/search/code", "core"},
+	}
+	for _, c := range cases {
+		if got := CategoryFor(c.url); got != c.want { t.Errorf("CategoryFor(%q) = %q, want %q", c.url, got, c.want) }
+	}
+}
+
+func TestAPIPath(t *testing.T) {
+	cases := []struct{ url, want string }{
+		{"https://api.github.com/repos/o/r", "/repos/o/r"},
+		{"https://api.github.com/graphql", "/graphql"},
+		{"https://ghe.example.com/api/v3/repos/o/r?page=2", "/repos/o/r"},
+		{"https://ghe.example.com/api/v3", ""},
+		{"https://ghe.example.com/api/graphql", "/graphql"},
+		{"https://ghe.example.com/api/v30/repos", "/api/v30/repos"},
+		{"/repos/o/r", "/repos/o/r"},
+	}
+	for _, c := range cases {
+		if got := APIPath(c.url); got != c.want { t.Errorf("APIPath(%q) = %q, want %q", c.url, got, c.want) }
+	}
+}
@@ -7,21 +7,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
-	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"gh-proxy/internal/config"
+	"gh-proxy/internal/db"
 )
 
 type Client struct {
-	pool *pgxpool.Pool
+	pool db.DB
 	http *http.Client
+	sched *scheduler
+	costs graphqlCosts
+	// same transport as http but without an overall timeout, for Stream
+	stream *http.Client
+	// GITHUB_API_URL; requests may only go to its scheme and host
+	api *url.URL
 }
 
-func New(pool *pgxpool.Pool) *Client {
+func New(pool db.DB, cfg config.Config) *Client {
 	// Optimized HTTP client for high throughput
 	transport := &http.Transport{
 		MaxIdleConns:        100,
@@ -31,12 +40,17 @@ func New(pool *pgxpool.Pool) *Client {
 		ResponseHeaderTimeout: 10 * time.Second,
 	}
 	
+	api, err := url.Parse(cfg.GithubAPIURL)
+	if err != nil || api.Host == "" || (api.Scheme != "https" && api.Scheme != "http") { log.Fatalf("invalid GITHUB_API_URL %q", cfg.GithubAPIURL) }
 	return &Client{
 		pool: pool, 
+		api: api,
+		sched: newScheduler(pool, int(cfg.TokenMaxConcurrent), int(cfg.TokenPointsPerMinute), int(cfg.TokenGraphQLPointsPerMinute)),
 		http: &http.Client{
 			Timeout: 15 * time.Second, // Faster timeout for high throughput
 			Transport: transport,
 		},
+		stream: &http.Client{Transport: transport},
 	}
 }
 
@@ -56,88 +70,303 @@ type rateAPIResp struct {
 }
 
 func (c *Client) refreshRate(ctx context.Context, tokenID string, token string) {
-	req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/rate_limit", nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", c.api.String()+"/rate_limit", nil)
 	req.Header.Set("Accept", "application/vnd.github+json")
 	if token != "" { req.Header.Set("Authorization", "Bearer "+token) }
 	resp, err := c.http.Do(req)
 	if err != nil { return }
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return }
 	var rr rateAPIResp
 	_ = json.NewDecoder(resp.Body).Decode(&rr)
 	for k, v := range rr.Resources {
-		reset := time.Unix(v.Reset, 0)
-		_, _ = c.pool.Exec(ctx, `INSERT INTO token_rate_limits(token_id,category,rate_limit,remaining,reset,updated_at) VALUES($1,$2,$3,$4,$5,now()) ON CONFLICT (token_id,category) DO UPDATE SET rate_limit=EXCLUDED.rate_limit, remaining=EXCLUDED.remaining, reset=EXCLUDED.reset, updated_at=now()`, tokenID, k, v.Limit, v.Remaining, reset)
+		l := limitCat{Limit: v.Limit, Remaining: v.Remaining, Reset: time.Unix(v.Reset, 0)}
+		c.sched.update(tokenID, k, l)
+		c.saveRate(ctx, tokenID, k, l)
 	}
 	_, _ = c.pool.Exec(ctx, `UPDATE donated_tokens SET last_ok_at=now() WHERE id=$1`, tokenID)
 }
 
-func (c *Client) chooseToken(ctx context.Context, category string) (id string, token string, err error) {
-	rows, err := c.pool.Query(ctx, `SELECT id::text, token FROM donated_tokens WHERE revoked=false ORDER BY COALESCE(last_ok_at, 'epoch') ASC`)
-	if err != nil { return "", "", err }
-	defer rows.Close()
-	type tk struct{ id, token string; remaining int; reset time.Time }
-	var toks []tk
+func (c *Client) saveRate(ctx context.Context, tokenID, category string, l limitCat) {
+	_, _ = c.pool.Exec(ctx, `INSERT INTO token_rate_limits(token_id,category,rate_limit,remaining,reset,updated_at) VALUES($1,$2,$3,$4,$5,now()) ON CONFLICT (token_id,category) DO UPDATE SET rate_limit=EXCLUDED.rate_limit, remaining=EXCLUDED.remaining, reset=EXCLUDED.reset, updated_at=now()`, tokenID, category, l.Limit, l.Remaining, l.Reset)
+}
+
+// rateFromHeaders reads the X-RateLimit-* headers GitHub sends on API responses.
+func rateFromHeaders(h http.Header) (resource string, l limitCat, ok bool) {
+	limit, err1 := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil { return "", l, false }
+	return h.Get("X-RateLimit-Resource"), limitCat{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}, true
+}
+
+// recordResponse applies the rate limit reported on a response to the token
+// in memory; RateRefresher writes it to Postgres with the next batch.
+func (c *Client) recordResponse(tokenID, category string, h http.Header) {
+	resource, l, ok := rateFromHeaders(h)
+	// the header names the bucket actually charged; fall back to our guess from the URL
+	if resource == "" { resource = category }
+	c.sched.observe(tokenID, resource, l, ok)
+}
+
+// flushRates writes the rate limits and successful responses seen since the
+// last flush, one statement each. A newer /rate_limit result already in the
+// table is kept.
+func (c *Client) flushRates(ctx context.Context) {
+	rates, oks := c.sched.unflushed()
+	if len(rates) > 0 {
+		var ids, cats []string
+		var limits, remaining []int32
+		var resets, seen []time.Time
+		for k, v := range rates {
+			ids, cats = append(ids, k.id), append(cats, k.category)
+			limits, remaining = append(limits, int32(v.Limit)), append(remaining, int32(v.Remaining))
+			resets, seen = append(resets, v.Reset), append(seen, v.at)
+		}
+		_, err := c.pool.Exec(ctx, `INSERT INTO token_rate_limits(token_id,category,rate_limit,remaining,reset,updated_at) SELECT d.id, v.category, v.lim, v.remaining, v.reset, v.seen FROM unnest($1::text[], $2::text[], $3::int[], $4::int[], $5::timestamptz[], $6::timestamptz[]) AS v(id, category, lim, remaining, reset, seen), donated_tokens d WHERE d.id::text=v.id ON CONFLICT (token_id,category) DO UPDATE SET rate_limit=EXCLUDED.rate_limit, remaining=EXCLUDED.remaining, reset=EXCLUDED.reset, updated_at=EXCLUDED.updated_at WHERE token_rate_limits.updated_at < EXCLUDED.updated_at`, ids, cats, limits, remaining, resets, seen)
+		if err != nil { log.Printf("rate flush: %v", err) }
+	}
+	if len(oks) > 0 {
+		var ids []string
+		var at []time.Time
+		for id, t := range oks { ids, at = append(ids, id), append(at, t) }
+		_, err := c.pool.Exec(ctx, `UPDATE donated_tokens d SET last_ok_at=v.at FROM unnest($1::text[], $2::timestamptz[]) AS v(id, at) WHERE d.id::text=v.id AND (d.last_ok_at IS NULL OR d.last_ok_at < v.at)`, ids, at)
+		if err != nil { log.Printf("rate flush: %v", err) }
+	}
+}
+
+// TokenSync keeps the in-memory token pool in sync with Postgres.
+func (c *Client) TokenSync() { c.sched.run() }
+
+// TokensChanged asks for an immediate token pool reload, e.g. after a donation.
+func (c *Client) TokensChanged() { c.sched.notify() }
+
+// tokens whose limits haven't been seen on a response for this long get a /rate_limit call
+const rateStaleAfter = 10 * time.Minute
+
+// RateRefresher writes the limits seen on responses to Postgres in batches,
+// then calls /rate_limit for tokens whose limits have gone stale (idle, or
+// newly donated), so budgets stay current without a call or write per request.
+func (c *Client) RateRefresher() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		c.flushRates(context.Background())
+		c.refreshStaleRates(context.Background())
+		<-t.C
+	}
+}
+
+func (c *Client) refreshStaleRates(ctx context.Context) {
+	rows, err := c.pool.Query(ctx, `SELECT d.id::text, d.token FROM donated_tokens d LEFT JOIN token_rate_limits t ON t.token_id=d.id WHERE d.revoked=false GROUP BY d.id, d.token HAVING COALESCE(MAX(t.updated_at), 'epoch') < $1`, time.Now().Add(-rateStaleAfter))
+	if err != nil { log.Printf("rate refresher: %v", err); return }
+	type tk struct{ id, token string }
+	var stale []tk
 	for rows.Next() {
-		var id, tkstr string
-		if err := rows.Scan(&id, &tkstr); err != nil { return "", "", err }
-		var rem int; var reset time.Time
-		_ = c.pool.QueryRow(ctx, `SELECT remaining, reset FROM token_rate_limits WHERE token_id=$1 AND category=$2`, id, category).Scan(&rem, &reset)
-		toks = append(toks, tk{id: id, token: tkstr, remaining: rem, reset: reset})
+		var t tk
+		if err := rows.Scan(&t.id, &t.token); err != nil { rows.Close(); return }
+		stale = append(stale, t)
 	}
-	if len(toks) == 0 { return "", "", errors.New("no donated tokens") }
-	sort.Slice(toks, func(i,j int) bool { if toks[i].remaining==toks[j].remaining { return toks[i].reset.Before(toks[j].reset) }; return toks[i].remaining>toks[j].remaining })
-	ch := toks[0]
-	return ch.id, ch.token, nil
+	rows.Close()
+	for _, t := range stale { c.refreshRate(ctx, t.id, t.token) }
 }
 
-func categoryFor(url string) string {
-	if strings.Contains(url, "/graphql") { return "graphql" }
-	if strings.Contains(url, "/search/code") { return "code_search" }
-	if strings.Contains(url, "/search/") { return "search" }
-	return "core"
+// Budget sums remaining and total requests for a category across unrevoked
+// tokens; a category whose reset has passed counts as fully available.
+func (c *Client) Budget(ctx context.Context, category string) (remaining, limit int64, err error) {
+	remaining, limit = c.sched.budget(category)
+	return remaining, limit, nil
 }
 
 func (c *Client) Do(ctx context.Context, method, rawURL string, body []byte) (status int, headers http.Header, respBody []byte, usedToken string, err error) {
+	return c.DoWithHeaders(ctx, method, rawURL, body, nil)
+}
+
+// DoWithHeaders is Do with extra request headers layered over the defaults,
+// e.g. the client's Accept / X-GitHub-Api-Version or If-None-Match.
+//
+// A token that comes back rate limited (primary or secondary) is benched until
+// its reset and the request is retried on the next-best token. GitHub's own
+// rate limit response never reaches the caller: when no token has budget left
+// the error is an *ExhaustedError.
+//
+// GraphQL queries are charged their estimated point cost, so a heavy query
+// only goes to a token that can still afford it instead of failing halfway
+// through a pagination run on a nearly drained one.
+func (c *Client) DoWithHeaders(ctx context.Context, method, rawURL string, body []byte, extra http.Header) (status int, headers http.Header, respBody []byte, usedToken string, err error) {
+	resp, id, err := c.open(ctx, c.http, method, rawURL, body, extra)
+	if resp == nil { return 0, nil, nil, id, err }
+	defer resp.Body.Close()
+	respBody, rerr := io.ReadAll(resp.Body)
+	if err == nil { err = rerr }
+	return resp.StatusCode, resp.Header, respBody, id, err
+}
+
+// Stream is DoWithHeaders without buffering the response body: the caller
+// reads it and must close it, which also frees the token for other requests.
+// There is no overall deadline besides ctx, so large downloads (tarballs, raw
+// content) aren't cut off; instead the request is cancelled once a read of
+// the body has waited streamIdleTimeout for data.
+func (c *Client) Stream(ctx context.Context, method, rawURL string, body []byte, extra http.Header) (status int, headers http.Header, respBody io.ReadCloser, usedToken string, err error) {
+	hc := c.stream
+	// GraphQL responses are read whole inside open, so they keep the overall timeout
+	if CategoryFor(rawURL) == "graphql" { hc = c.http }
+	ctx, cancel := context.WithCancel(ctx)
+	resp, id, err := c.open(ctx, hc, method, rawURL, body, extra)
+	if resp == nil { cancel(); return 0, nil, nil, id, err }
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	idle.Stop()
+	return resp.StatusCode, resp.Header, &idleBody{ReadCloser: resp.Body, idle: idle, cancel: cancel}, id, err
+}
+
+// how long a streamed body may go without data before the request is abandoned
+const streamIdleTimeout = 30 * time.Second
+
+// idleBody cancels a streamed request when a Read blocks for streamIdleTimeout.
+// Only time spent waiting on GitHub counts, not time the caller spends writing.
+type idleBody struct {
+	io.ReadCloser
+	idle *time.Timer
+	cancel context.CancelFunc
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.idle.Reset(streamIdleTimeout)
+	n, err := b.ReadCloser.Read(p)
+	b.idle.Stop()
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.idle.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// open picks a token and sends the request, retrying rate limited tokens. The
+// returned body holds the token's concurrency slot until it is closed.
+func (c *Client) open(ctx context.Context, hc *http.Client, method, rawURL string, body []byte, extra http.Header) (*http.Response, string, error) {
 	parsed, perr := url.Parse(rawURL)
-	if perr != nil { return 0, nil, nil, "", fmt.Errorf("invalid url: %w", perr) }
-	if parsed.Scheme != "https" || parsed.Host != "api.github.com" {
-		return 0, nil, nil, "", fmt.Errorf("disallowed request target")
+	if perr != nil { return nil, "", fmt.Errorf("invalid url: %w", perr) }
+	if parsed.Scheme != c.api.Scheme || parsed.Host != c.api.Host {
+		return nil, "", fmt.Errorf("disallowed request target")
 	}
 	safeURL := parsed.String()
-	cat := categoryFor(safeURL)
-	id, token, err := c.chooseToken(ctx, cat)
-	if err != nil { return 0, nil, nil, "", err }
+	cat := CategoryFor(safeURL)
+	cost := 1
+	if cat == "graphql" { cost = c.costs.cost(body) }
+	// every limited token is benched, so this ends once pick runs out of tokens;
+	// tried catches a token whose bench is already over (e.g. Retry-After: 0)
+	tried := map[string]bool{}
+	for {
+		id, token, err := c.sched.pick(ctx, cat, cost, pointsFor(method, cat, body))
+		if err != nil { return nil, "", err }
+		if tried[id] {
+			c.sched.release(id)
+			return nil, "", &ExhaustedError{Category: cat}
+		}
+		tried[id] = true
+		resp, small, err := c.send(ctx, hc, method, safeURL, body, extra, id, token, cat)
+		if err != nil || resp == nil {
+			c.sched.release(id)
+			return resp, id, err
+		}
+		until, secondary, limited := rateLimited(resp.StatusCode, resp.Header, small)
+		if !limited {
+			if cat == "graphql" {
+				// GraphQL responses are small JSON; read it to learn the query's real cost
+				b, rerr := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if rerr != nil { c.sched.release(id); return nil, id, rerr }
+				c.costs.observe(body, b)
+				resp.Body = io.NopCloser(bytes.NewReader(b))
+			}
+			resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { c.sched.release(id) }}
+			return resp, id, nil
+		}
+		c.sched.exhaust(id, cat, until, secondary)
+		log.Printf("token %s rate limited on %s until %s (attempt %d)", id, cat, until.Format(time.TimeOnly), len(tried))
+		resp.Body.Close()
+		c.sched.release(id)
+	}
+}
+
+// releaseOnClose frees a token's concurrency slot once its response body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// send makes one upstream call with a specific token. Error statuses (401,
+// 403, 429) are small JSON bodies and are read up front so they can be
+// inspected; small holds them, and resp.Body replays them.
+func (c *Client) send(ctx context.Context, hc *http.Client, method, safeURL string, body []byte, extra http.Header, id, token, cat string) (resp *http.Response, small []byte, err error) {
 	req, err := http.NewRequestWithContext(ctx, method, safeURL, bytes.NewReader(body))
-	if err != nil { return 0, nil, nil, "", err }
+	if err != nil { return nil, nil, err }
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 	req.Header.Set("User-Agent", "gh-proxy/1.0")
+	for k, v := range extra { req.Header[k] = v }
 	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := c.http.Do(req)
-	if err != nil { return 0, nil, nil, "", err }
-	defer resp.Body.Close()
-	b, _ := io.ReadAll(resp.Body)
+	resp, err = hc.Do(req)
+	if err != nil { return nil, nil, err }
+	if resp.StatusCode == 401 || resp.StatusCode == 403 || resp.StatusCode == 429 {
+		small, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(small))
+	}
 	if resp.StatusCode == 401 || resp.StatusCode == 403 {
-		var user string
-		_ = c.pool.QueryRow(ctx, `SELECT github_user FROM donated_tokens WHERE id::text=$1`, id).Scan(&user)
 		// Only revoke on 401 or explicit bad credentials
 		shouldRevoke := resp.StatusCode == 401
 		if resp.StatusCode == 403 {
 			var em struct{ Message string `json:"message"` }
-			_ = json.Unmarshal(b, &em)
+			_ = json.Unmarshal(small, &em)
 			if strings.Contains(strings.ToLower(em.Message), "bad credentials") {
 				shouldRevoke = true
 			}
 		}
 		if shouldRevoke {
+			var user string
+			_ = c.pool.QueryRow(ctx, `SELECT github_user FROM donated_tokens WHERE id::text=$1`, id).Scan(&user)
 			_, _ = c.pool.Exec(ctx, `UPDATE donated_tokens SET revoked=true WHERE id=$1`, id)
+			c.sched.remove(id)
 			logMsg := "token unauthorized; marked revoked"
 			if user != "" { logMsg += " (@" + user + ")" }
-			return resp.StatusCode, resp.Header, b, id, fmt.Errorf(logMsg)
+			return resp, small, errors.New(logMsg)
 		}
 	}
-	// update rate limits from headers if present
-	// Alternatively call /rate_limit periodically
-	go c.refreshRate(context.Background(), id, token)
-	return resp.StatusCode, resp.Header, b, id, nil
+	// GitHub reports the token's remaining budget on every response; stale tokens are covered by RateRefresher
+	c.recordResponse(id, cat, resp.Header)
+	return resp, small, nil
+}
+
+// how long to bench a token after a secondary rate limit without Retry-After
+const secondaryBackoff = time.Minute
+
+// rateLimited reports whether GitHub rejected a request because of the token's
+// primary (X-RateLimit-Remaining: 0) or secondary rate limit, and until when
+// the token should be left alone.
+func rateLimited(status int, h http.Header, body []byte) (until time.Time, secondary, ok bool) {
+	if status != http.StatusForbidden && status != http.StatusTooManyRequests { return }
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(secs) * time.Second), true, true
+	}
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil { return time.Unix(reset, 0), false, true }
+		return time.Now().Add(secondaryBackoff), false, true
+	}
+	var em struct{ Message string `json:"message"` }
+	_ = json.Unmarshal(body, &em)
+	if msg := strings.ToLower(em.Message); strings.Contains(msg, "secondary rate limit") || strings.Contains(msg, "abuse") {
+		return time.Now().Add(secondaryBackoff), true, true
+	}
+	return
 }
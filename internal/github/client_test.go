@@ -0,0 +1,113 @@
+package github_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gh-proxy/internal/config"
+	"gh-proxy/internal/db/dbtest"
+	"gh-proxy/internal/fakegithub"
+	gh "gh-proxy/internal/github"
+)
+
+// testClient points a Client at a fresh fake GitHub holding the given tokens
+// (ids "1", "2", ...), each with a little less core budget than the one before
+// so they are picked in order.
+func testClient(t *testing.T, tokens ...string) (*gh.Client, *fakegithub.Server, *dbtest.DB, string) {
+	t.Helper()
+	fake := fakegithub.New(5000, time.Hour)
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	d := dbtest.New()
+	reset, now := time.Now().Add(time.Hour), time.Now()
+	var rows [][]any
+	for i, tok := range tokens {
+		fake.AddToken(tok, "user"+strconv.Itoa(i+1))
+		rows = append(rows, []any{strconv.Itoa(i + 1), tok, "core", 5000, 5000 - i*100, reset, now})
+	}
+	d.On("FROM donated_tokens d LEFT JOIN token_rate_limits", rows...)
+	return gh.New(d, config.Config{GithubAPIURL: srv.URL}), fake, d, srv.URL
+}
+
+func TestClientNotModified(t *testing.T) {
+	c, _, _, api := testClient(t, "tok-a")
+	ctx := context.Background()
+	_, h, _, _, err := c.Do(ctx, "GET", api+"/user", nil)
+	if err != nil { t.Fatal(err) }
+	etag := h.Get("ETag")
+	status, h, body, id, err := c.DoWithHeaders(ctx, "GET", api+"/user", nil, http.Header{"If-None-Match": {etag}})
+	if err != nil { t.Fatal(err) }
+	if status != http.StatusNotModified || len(body) != 0 || h.Get("ETag") != etag { t.Fatalf("got %d %q etag %q, want an empty 304", status, body, h.Get("ETag")) }
+	if id != "1" { t.Fatalf("token %q", id) }
+	// the 304 was free, and the budget from its headers is applied
+	if rem, _, _ := c.Budget(ctx, "core"); rem != 4999 { t.Fatalf("core remaining = %d, want 4999", rem) }
+}
+
+func TestClientRetriesPrimaryLimit(t *testing.T) {
+	c, fake, _, api := testClient(t, "tok-a", "tok-b")
+	fake.Fail("tok-a", fakegithub.FailPrimary, 1)
+	status, _, body, id, err := c.Do(context.Background(), "GET", api+"/user", nil)
+	if err != nil { t.Fatal(err) }
+	if status != http.StatusOK || id != "2" { t.Fatalf("got %d from token %q: %s", status, id, body) }
+	if fake.Requests("tok-a") != 1 || fake.Requests("tok-b") != 1 { t.Fatalf("requests a=%d b=%d", fake.Requests("tok-a"), fake.Requests("tok-b")) }
+	// a stays benched for core, so the next request goes straight to b
+	if _, _, _, id, _ := c.Do(context.Background(), "GET", api+"/user", nil); id != "2" { t.Fatalf("second request used token %q", id) }
+	if fake.Requests("tok-a") != 1 { t.Fatal("benched token was tried again") }
+}
+
+func TestClientRetriesUntilATokenHasBudget(t *testing.T) {
+	c, fake, _, api := testClient(t, "tok-a", "tok-b", "tok-c", "tok-d")
+	for _, tok := range []string{"tok-a", "tok-b", "tok-c"} { fake.Fail(tok, fakegithub.FailPrimary, 1) }
+	status, _, body, id, err := c.Do(context.Background(), "GET", api+"/user", nil)
+	if err != nil { t.Fatal(err) }
+	if status != http.StatusOK || id != "4" { t.Fatalf("got %d from token %q: %s", status, id, body) }
+	for _, tok := range []string{"tok-a", "tok-b", "tok-c", "tok-d"} {
+		if n := fake.Requests(tok); n != 1 { t.Fatalf("%s made %d requests, want 1", tok, n) }
+	}
+}
+
+func TestClientAllTokensLimited(t *testing.T) {
+	c, fake, _, api := testClient(t, "tok-a", "tok-b", "tok-c", "tok-d")
+	for _, tok := range []string{"tok-a", "tok-b", "tok-c", "tok-d"} { fake.Fail(tok, fakegithub.FailPrimary, 1) }
+	status, _, _, _, err := c.Do(context.Background(), "GET", api+"/user", nil)
+	// GitHub's 403 isn't handed back; the caller learns when the pool has budget again
+	var exhausted *gh.ExhaustedError
+	if status != 0 || !errors.As(err, &exhausted) || exhausted.Category != "core" { t.Fatalf("got %d, err %v; want an *ExhaustedError for core", status, err) }
+	if exhausted.RetryAt.IsZero() { t.Fatal("no RetryAt") }
+}
+
+func TestClientRetriesSecondaryLimit(t *testing.T) {
+	c, fake, _, api := testClient(t, "tok-a", "tok-b")
+	fake.Fail("tok-a", fakegithub.FailSecondary, 1)
+	status, _, _, id, err := c.Do(context.Background(), "GET", api+"/user", nil)
+	if err != nil { t.Fatal(err) }
+	if status != http.StatusOK || id != "2" { t.Fatalf("got %d from token %q", status, id) }
+	// a secondary limit benches the token for every category
+	if _, _, _, id, _ := c.Do(context.Background(), "GET", api+"/search/repositories?q=x", nil); id != "2" { t.Fatalf("search used token %q", id) }
+	if fake.Requests("tok-a") != 1 { t.Fatalf("benched token was tried again: %d requests", fake.Requests("tok-a")) }
+}
+
+func TestClientRevokesOnUnauthorized(t *testing.T) {
+	c, fake, d, api := testClient(t, "tok-a", "tok-b")
+	fake.Revoke("tok-a")
+	status, _, _, id, err := c.Do(context.Background(), "GET", api+"/user", nil)
+	if err == nil || status != http.StatusUnauthorized || id != "1" { t.Fatalf("got %d from token %q, err %v; want a 401 error from token 1", status, id, err) }
+	if !d.Executed("SET revoked=true") { t.Fatal("token not marked revoked in the database") }
+	if _, _, _, id, err := c.Do(context.Background(), "GET", api+"/user", nil); err != nil || id != "2" { t.Fatalf("next request: token %q, err %v", id, err) }
+	if fake.Requests("tok-a") != 1 { t.Fatal("revoked token was tried again") }
+}
+
+func TestClientExhausted(t *testing.T) {
+	c, fake, _, api := testClient(t, "tok-a")
+	fake.SetRemaining("tok-a", "core", 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, _, _, err := c.Do(ctx, "GET", api+"/user", nil)
+	var exhausted *gh.ExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Category != "core" { t.Fatalf("err = %v, want an *ExhaustedError for core", err) }
+}
@@ -0,0 +1,182 @@
+package github
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// GraphQL queries are charged by how many nodes they could return rather than
+// per request. estimateGraphQLCost follows GitHub's published formula: every
+// connection (a field with first/last) costs one request per parent node,
+// assuming each connection is full; the total divided by 100 and rounded is
+// the point cost, at least 1. Fragments are not expanded, so queries built
+// from fragments are underestimated; the cost GitHub reports back (when the
+// query selects rateLimit { cost }) is remembered per query and wins when higher.
+func estimateGraphQLCost(body []byte) int {
+	var req struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil { return 1 }
+	toks := graphqlTokens(req.Query)
+	mult := []float64{1} // nodes per selection set, by brace depth
+	pending := 0.0       // multiplier for the next selection set, after a connection's arguments
+	requests := 0.0
+	for i := 0; i < len(toks); i++ {
+		switch t := toks[i]; {
+		case t == "{":
+			m := mult[len(mult)-1]
+			if pending > 0 { m = pending }
+			mult = append(mult, m)
+			pending = 0
+		case t == "}":
+			if len(mult) > 1 { mult = mult[:len(mult)-1] }
+			pending = 0
+		case t == "(":
+			n := 0.0
+			depth := 1
+			for i++; i < len(toks) && depth > 0; i++ {
+				switch toks[i] {
+				case "(": depth++
+				case ")": depth--
+				case "first", "last":
+					if depth == 1 && i+2 < len(toks) && toks[i+1] == ":" { n = graphqlArgValue(toks[i+2], req.Variables) }
+				}
+			}
+			i--
+			if n > 0 {
+				parent := mult[len(mult)-1]
+				requests += parent
+				pending = parent * n
+			}
+		case isGraphQLName(t) && (i == 0 || toks[i-1] != "@"):
+			// a new field (not a directive) ends the previous field's arguments
+			pending = 0
+		}
+	}
+	cost := int(math.Round(requests / 100))
+	if cost < 1 { cost = 1 }
+	return cost
+}
+
+// isGraphQLMutation reports whether a GraphQL request body defines a mutation.
+func isGraphQLMutation(body []byte) bool {
+	var req struct{ Query string `json:"query"` }
+	if err := json.Unmarshal(body, &req); err != nil { return false }
+	depth := 0
+	for _, t := range graphqlTokens(req.Query) {
+		switch t {
+		case "{": depth++
+		case "}": depth--
+		case "mutation":
+			if depth == 0 { return true }
+		}
+	}
+	return false
+}
+
+// GitHub caps first/last at 100
+const maxConnectionSize = 100
+
+func graphqlArgValue(tok string, vars map[string]any) float64 {
+	var n float64
+	if len(tok) > 1 && tok[0] == '$' {
+		v, _ := vars[tok[1:]].(float64)
+		n = v
+	} else if v, err := strconv.ParseFloat(tok, 64); err == nil {
+		n = v
+	}
+	return math.Min(n, maxConnectionSize)
+}
+
+// graphqlTokens splits a GraphQL document into names, $variables, numbers and
+// punctuators; strings become "" and comments, commas and whitespace are dropped.
+func graphqlTokens(q string) []string {
+	var toks []string
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(q) && q[i] != '\n' { i++ }
+		case c == '"':
+			if i+2 < len(q) && q[i+1] == '"' && q[i+2] == '"' {
+				j := i + 3
+				for j+2 < len(q) && !(q[j] == '"' && q[j+1] == '"' && q[j+2] == '"' && q[j-1] != '\\') { j++ }
+				i = j + 3
+			} else {
+				j := i + 1
+				for j < len(q) && q[j] != '"' && q[j] != '\n' {
+					if q[j] == '\\' { j++ }
+					j++
+				}
+				i = j + 1
+			}
+			toks = append(toks, `""`)
+		case c == '.' && i+2 < len(q) && q[i+1] == '.' && q[i+2] == '.':
+			toks = append(toks, "...")
+			i += 3
+		case c == '$' || c == '_' || c == '-' || (c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'z'):
+			j := i + 1
+			for j < len(q) && (q[j] == '_' || q[j] == '.' || (q[j] >= '0' && q[j] <= '9') || (q[j]|0x20 >= 'a' && q[j]|0x20 <= 'z')) { j++ }
+			toks = append(toks, q[i:j])
+			i = j
+		default:
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks
+}
+
+func isGraphQLName(t string) bool {
+	c := t[0]
+	return c == '_' || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
+
+// graphqlCosts remembers the cost GitHub reported for each query text, so
+// later pages of the same query are budgeted by what they really cost.
+type graphqlCosts struct {
+	mu sync.Mutex
+	m map[[32]byte]int
+}
+
+// forget everything past this many distinct queries rather than tracking recency
+const maxKnownQueries = 2000
+
+func queryKey(body []byte) ([32]byte, bool) {
+	var req struct{ Query string `json:"query"` }
+	if err := json.Unmarshal(body, &req); err != nil || req.Query == "" { return [32]byte{}, false }
+	return sha256.Sum256([]byte(req.Query)), true
+}
+
+// cost is the larger of the static estimate and the last reported cost.
+func (g *graphqlCosts) cost(body []byte) int {
+	est := estimateGraphQLCost(body)
+	k, ok := queryKey(body)
+	if !ok { return est }
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if known := g.m[k]; known > est { return known }
+	return est
+}
+
+// observe records the cost a response reported in data.rateLimit.cost, if selected.
+func (g *graphqlCosts) observe(reqBody, respBody []byte) {
+	var r struct {
+		Data struct {
+			RateLimit struct{ Cost int `json:"cost"` } `json:"rateLimit"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &r); err != nil || r.Data.RateLimit.Cost <= 0 { return }
+	k, ok := queryKey(reqBody)
+	if !ok { return }
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil || len(g.m) >= maxKnownQueries { g.m = map[[32]byte]int{} }
+	g.m[k] = r.Data.RateLimit.Cost
+}
@@ -0,0 +1,81 @@
+package github
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func gqlBody(query string, vars map[string]any) []byte {
+	b, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+	return b
+}
+
+func TestEstimateGraphQLCost(t *testing.T) {
+	cases := []struct {
+		name string
+		query string
+		vars map[string]any
+		want int
+	}{
+		{"no connections", `{ viewer { login } }`, nil, 1},
+		{"one connection", `{ viewer { repositories(first: 100) { nodes { name } } } }`, nil, 1},
+		// GitHub's documented example: 1 + 100 + 100*50 = 5101 requests
+		{"documented example", `query { viewer { login repositories(first: 100) { edges { node { id issues(first: 50) { edges { node { id labels(first: 60) { edges { node { id name } } } } } } } } } } }`, nil, 51},
+		{"two levels", `{ viewer { repositories(first: 50) { nodes { issues(first: 10) { nodes { title } } } } } }`, nil, 1},
+		{"three levels of 100", `{ viewer { repositories(first: 100) { nodes { issues(first: 100) { nodes { comments(first: 100) { nodes { body } } } } } } } }`, nil, 101},
+		{"last counts like first", `{ viewer { repositories(last: 100) { nodes { issues(last: 100) { nodes { comments(last: 100) { nodes { body } } } } } } } }`, nil, 101},
+		{"variables", `query($n: Int!) { viewer { repositories(first: $n) { nodes { issues(first: $n) { nodes { comments(first: $n) { nodes { body } } } } } } } }`, map[string]any{"n": 100}, 101},
+		{"missing variable", `query($n: Int) { viewer { repositories(first: $n) { nodes { issues(first: 100) { nodes { title } } } } } }`, nil, 1},
+		{"capped at 100", `{ viewer { repositories(first: 1000) { nodes { issues(first: 1000) { nodes { comments(first: 1000) { nodes { body } } } } } } } }`, nil, 101},
+		// siblings each cost one request per parent node
+		{"siblings", `{ viewer { repositories(first: 100) { nodes { issues(first: 100) { nodes { title } } pullRequests(first: 100) { nodes { title } } } } } }`, nil, 2},
+		{"other arguments", `{ repository(owner: "o", name: "r") { issues(first: 100, states: OPEN, orderBy: {field: CREATED_AT, direction: DESC}) { nodes { comments(first: 100) { nodes { body } } } } } }`, nil, 1},
+		{"connection without a selection", `{ viewer { repositories(first: 100) { totalCount } followers(first: 100) { totalCount } } }`, nil, 1},
+		{"comments and strings", "{ viewer { # repositories(first: 100) { nodes { issues(first: 100) } }\n  bio(format: \"first: 100\") } }", nil, 1},
+	}
+	for _, c := range cases {
+		if got := estimateGraphQLCost(gqlBody(c.query, c.vars)); got != c.want { t.Errorf("%s: estimateGraphQLCost = %d, want %d", c.name, got, c.want) }
+	}
+	if got := estimateGraphQLCost([]byte("{")); got != 1 { t.Errorf("not JSON: estimateGraphQLCost = %d, want 1", got) }
+}
+
+func TestGraphQLCostsReported(t *testing.T) {
+	var g graphqlCosts
+	q := gqlBody(`query { viewer { repositories(first: 100) { nodes { ...Repo } } } } fragment Repo on Repository { issues(first: 100) { nodes { comments(first: 100) { nodes { body } } } } }`, nil)
+	// fragments aren't expanded, so the estimate is low until GitHub reports the real cost
+	if got := g.cost(q); got != 1 { t.Fatalf("cost before a report = %d, want the estimate 1", got) }
+	g.observe(q, []byte(`{"data":{"rateLimit":{"cost":101,"remaining":4899}}}`))
+	if got := g.cost(q); got != 101 { t.Fatalf("cost after a report = %d, want 101", got) }
+	// the same query text with other variables shares the report
+	if got := g.cost(gqlBody(`query { viewer { repositories(first: 100) { nodes { ...Repo } } } } fragment Repo on Repository { issues(first: 100) { nodes { comments(first: 100) { nodes { body } } } } }`, map[string]any{"x": 1})); got != 101 { t.Fatalf("cost with other variables = %d, want 101", got) }
+
+	// a reported cost below the estimate doesn't lower it
+	heavy := gqlBody(`{ viewer { repositories(first: 100) { nodes { issues(first: 100) { nodes { comments(first: 100) { nodes { body } } } } } } } }`, nil)
+	g.observe(heavy, []byte(`{"data":{"rateLimit":{"cost":3}}}`))
+	if got := g.cost(heavy); got != 101 { t.Fatalf("cost = %d, want the estimate 101", got) }
+
+	// responses without rateLimit, or with errors only, change nothing
+	other := gqlBody(`{ viewer { login } }`, nil)
+	g.observe(other, []byte(`{"data":{"viewer":{"login":"u"}}}`))
+	g.observe(other, []byte(`{"errors":[{"type":"RATE_LIMITED"}]}`))
+	if got := g.cost(other); got != 1 { t.Fatalf("cost = %d, want 1", got) }
+}
+
+func TestIsGraphQLMutation(t *testing.T) {
+	cases := []struct {
+		query string
+		want bool
+	}{
+		{`{ viewer { login } }`, false},
+		{`query Q { viewer { login } }`, false},
+		{`mutation { addStar(input: {starrableId: "x"}) { clientMutationId } }`, true},
+		{"# comment\nmutation Star($id: ID!) { addStar(input: {starrableId: $id}) { clientMutationId } }", true},
+		{`query Q { viewer { login } } mutation M { addStar(input: {}) { clientMutationId } }`, true},
+		{`{ viewer { mutation } }`, false},
+		{`{ search(query: "mutation") { issueCount } }`, false},
+	}
+	for _, c := range cases {
+		if got := isGraphQLMutation(gqlBody(c.query, nil)); got != c.want { t.Errorf("isGraphQLMutation(%q) = %v, want %v", c.query, got, c.want) }
+	}
+	if isGraphQLMutation([]byte("mutation")) { t.Error("non-JSON body reported as a mutation") }
+}
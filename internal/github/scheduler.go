@@ -0,0 +1,450 @@
+package github
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gh-proxy/internal/db"
+)
+
+// scheduler keeps every donated token and its per-category budget in memory
+// so choosing a token never touches the database. Each category has a max-heap
+// ordered by remaining requests; a pick takes the top token and charges it the
+// request's cost up front (1 for REST, the estimated point cost for GraphQL),
+// which spreads load evenly and in proportion to each token's limit. Response
+// headers correct the estimate as they arrive.
+//
+// To stay clear of GitHub's secondary limits a token is also skipped while it
+// has maxInFlight requests running or has spent its points for the last minute
+// (pointsPerMinute for REST, graphqlPointsPerMinute for GraphQL, which GitHub
+// counts separately); when every token is busy, pick waits up to maxWait for
+// one to free up.
+//
+// The token list is reloaded from Postgres every tokenSyncInterval and when
+// TokensChanged is called (e.g. after an OAuth donation).
+type scheduler struct {
+	pool db.DB
+	mu sync.Mutex
+	loaded bool
+	tokens map[string]*schedToken // by donated_tokens.id
+	cats map[string]*catSched
+	changed chan struct{}
+	maxInFlight int // 0 = unlimited
+	pointsPerMinute, graphqlPointsPerMinute int // 0 = unlimited
+	maxWait time.Duration
+	freed chan struct{} // closed (and replaced) whenever a request finishes
+	// seen on responses but not yet written to Postgres; see Client.flushRates
+	unsaved map[rateKey]limitSeen
+	okAt map[string]time.Time
+}
+
+type rateKey struct{ id, category string }
+
+type schedToken struct {
+	id, token string
+	limits map[string]limitSeen // from response headers, /rate_limit or the DB
+	blockedUntil time.Time // secondary rate limit, applies to every category
+	inFlight int
+	points, graphqlPoints pointWindow
+}
+
+// window returns the points window a category's requests count against.
+func (t *schedToken) window(category string) *pointWindow {
+	if category == "graphql" { return &t.graphqlPoints }
+	return &t.points
+}
+
+// pointWindow counts secondary rate limit points spent per second over the last minute.
+type pointWindow struct {
+	sec [60]int64 // unix second each bucket belongs to
+	n [60]int
+}
+
+func (w *pointWindow) add(now time.Time, points int) {
+	sec := now.Unix()
+	i := sec % 60
+	if w.sec[i] != sec { w.sec[i], w.n[i] = sec, 0 }
+	w.n[i] += points
+}
+
+func (w *pointWindow) sum(now time.Time) int {
+	total, cutoff := 0, now.Unix()-60
+	for i := range w.n {
+		if w.sec[i] > cutoff { total += w.n[i] }
+	}
+	return total
+}
+
+// points charged against GitHub's secondary limit: 1 for reads and GraphQL queries, 5 for writes and GraphQL mutations
+func pointsFor(method, category string, body []byte) int {
+	if category == "graphql" {
+		if isGraphQLMutation(body) { return 5 }
+		return 1
+	}
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions { return 1 }
+	return 5
+}
+
+// ExhaustedError means no donated token has budget left in a category, or
+// every token was still busy after maxWait.
+type ExhaustedError struct {
+	Category string
+	RetryAt time.Time // earliest reset, zero if unknown
+}
+
+func (e *ExhaustedError) Error() string { return "all donated tokens are rate limited for " + e.Category }
+
+type limitSeen struct {
+	limitCat
+	at time.Time
+}
+
+// catSched is one category's heap.
+type catSched struct {
+	h budgetHeap
+	byToken map[string]*budget
+	nextReset time.Time // earliest future reset; budgets refill when it passes
+}
+
+type budget struct {
+	tok *schedToken
+	limit, remaining int
+	reset time.Time
+	known bool // limit came from GitHub rather than defaultLimit
+	lastPick time.Time
+	index int
+}
+
+const tokenSyncInterval = 30 * time.Second
+
+// how long pick waits for a busy token before giving up with an ExhaustedError
+const maxPickWait = 10 * time.Second
+
+// assumed limits for tokens GitHub hasn't told us about yet
+func defaultLimit(category string) int {
+	switch category {
+	case "search": return 30
+	case "code_search": return 10
+	default: return 5000
+	}
+}
+
+func newScheduler(pool db.DB, maxInFlight, pointsPerMinute, graphqlPointsPerMinute int) *scheduler {
+	return &scheduler{
+		pool: pool,
+		tokens: map[string]*schedToken{},
+		cats: map[string]*catSched{},
+		changed: make(chan struct{}, 1),
+		maxInFlight: maxInFlight,
+		pointsPerMinute: pointsPerMinute,
+		graphqlPointsPerMinute: graphqlPointsPerMinute,
+		maxWait: maxPickWait,
+		freed: make(chan struct{}),
+		unsaved: map[rateKey]limitSeen{},
+		okAt: map[string]time.Time{},
+	}
+}
+
+// sync reloads unrevoked tokens and stored rate limits, keeping whichever
+// limit observation is newer.
+func (s *scheduler) sync(ctx context.Context) error {
+	rows, err := s.pool.Query(ctx, `SELECT d.id::text, d.token, t.category, t.rate_limit, t.remaining, t.reset, t.updated_at FROM donated_tokens d LEFT JOIN token_rate_limits t ON t.token_id=d.id WHERE d.revoked=false`)
+	if err != nil { return err }
+	defer rows.Close()
+	fresh := map[string]*schedToken{}
+	for rows.Next() {
+		var id, tok string
+		var cat *string
+		var limit, remaining *int
+		var reset, at *time.Time
+		if err := rows.Scan(&id, &tok, &cat, &limit, &remaining, &reset, &at); err != nil { return err }
+		t, ok := fresh[id]
+		if !ok {
+			t = &schedToken{id: id, token: tok, limits: map[string]limitSeen{}}
+			fresh[id] = t
+		}
+		if cat != nil { t.limits[*cat] = limitSeen{limitCat{Limit: *limit, Remaining: *remaining, Reset: *reset}, *at} }
+	}
+	if err := rows.Err(); err != nil { return err }
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range fresh {
+		old, ok := s.tokens[id]
+		if !ok { continue }
+		t.blockedUntil, t.inFlight, t.points, t.graphqlPoints = old.blockedUntil, old.inFlight, old.points, old.graphqlPoints
+		for cat, seen := range old.limits {
+			if cur, ok := t.limits[cat]; !ok || seen.at.After(cur.at) { t.limits[cat] = seen }
+		}
+	}
+	s.tokens = fresh
+	s.cats = map[string]*catSched{} // rebuilt lazily per category
+	s.loaded = true
+	return nil
+}
+
+// run keeps the in-memory pool in sync with Postgres.
+func (s *scheduler) run() {
+	t := time.NewTicker(tokenSyncInterval)
+	defer t.Stop()
+	for {
+		if err := s.sync(context.Background()); err != nil { log.Printf("token scheduler: sync: %v", err) }
+		select {
+		case <-t.C:
+		case <-s.changed:
+		}
+	}
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// category returns the heap for a category, building it on first use. Caller holds mu.
+func (s *scheduler) category(name string) *catSched {
+	cs, ok := s.cats[name]
+	if ok { return cs }
+	cs = &catSched{byToken: map[string]*budget{}}
+	for _, t := range s.tokens {
+		b := &budget{tok: t, index: len(cs.h)}
+		b.set(name)
+		cs.h = append(cs.h, b)
+		cs.byToken[t.id] = b
+	}
+	cs.rebuild(time.Now())
+	s.cats[name] = cs
+	return cs
+}
+
+// set loads the budget from the token's latest observation for category.
+func (b *budget) set(category string) {
+	seen := b.tok.limits[category]
+	b.known = seen.Limit > 0
+	if !b.known { seen.Limit, seen.Remaining = defaultLimit(category), defaultLimit(category) }
+	b.limit, b.remaining, b.reset = seen.Limit, seen.Remaining, seen.Reset
+	if until := b.tok.blockedUntil; until.After(time.Now()) {
+		b.remaining = 0
+		if until.After(b.reset) { b.reset = until }
+	}
+}
+
+// reload re-reads one token's budget after its limits changed. Caller holds mu.
+func (cs *catSched) reload(category string, t *schedToken) {
+	b, ok := cs.byToken[t.id]
+	if !ok { return }
+	b.set(category)
+	if !b.reset.IsZero() && (cs.nextReset.IsZero() || b.reset.Before(cs.nextReset)) { cs.nextReset = b.reset }
+	heap.Fix(&cs.h, b.index)
+}
+
+// refill resets budgets once the earliest pending reset has passed, so picks
+// stay O(log n) in between.
+func (cs *catSched) refill(now time.Time) {
+	if cs.nextReset.IsZero() || now.Before(cs.nextReset) { return }
+	cs.rebuild(now)
+}
+
+// rebuild refills every budget whose window has passed and re-heapifies.
+func (cs *catSched) rebuild(now time.Time) {
+	cs.nextReset = time.Time{}
+	for _, b := range cs.h {
+		if !b.reset.IsZero() && !now.Before(b.reset) {
+			b.remaining, b.reset = b.limit, time.Time{}
+		}
+		if !b.reset.IsZero() && (cs.nextReset.IsZero() || b.reset.Before(cs.nextReset)) { cs.nextReset = b.reset }
+	}
+	heap.Init(&cs.h)
+}
+
+// pick chooses the token with the most budget left in a category that can
+// afford cost and is below its concurrency and points caps, and reserves a
+// slot on it; the caller must release it when the request is done.
+func (s *scheduler) pick(ctx context.Context, category string, cost, points int) (id, token string, err error) {
+	s.mu.Lock()
+	loaded := s.loaded
+	s.mu.Unlock()
+	if !loaded {
+		if err := s.sync(ctx); err != nil { return "", "", err }
+	}
+	deadline := time.NewTimer(s.maxWait)
+	defer deadline.Stop()
+	for {
+		id, token, wait, err := s.tryPick(category, cost, points)
+		if wait == nil { return id, token, err }
+		// points age out of the window without a request finishing, so look again every second too
+		t := time.NewTimer(time.Second)
+		select {
+		case <-wait:
+		case <-t.C:
+		case <-deadline.C:
+			t.Stop()
+			return "", "", &ExhaustedError{Category: category}
+		case <-ctx.Done():
+			t.Stop()
+			return "", "", ctx.Err()
+		}
+		t.Stop()
+	}
+}
+
+// tryPick returns a channel to wait on when every token with budget is at its
+// concurrency or points cap.
+func (s *scheduler) tryPick(category string, cost, points int) (id, token string, wait <-chan struct{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	cs := s.category(category)
+	cs.refill(now)
+	if len(cs.h) == 0 { return "", "", nil, errors.New("no donated tokens") }
+	// set aside tokens that are busy; budgets below the first one that can't afford cost can't either
+	var busy []*budget
+	defer func() {
+		for _, b := range busy { heap.Push(&cs.h, b) }
+	}()
+	saturated := false
+	perMinute := s.pointsPerMinute
+	if category == "graphql" { perMinute = s.graphqlPointsPerMinute }
+	for len(cs.h) > 0 && cs.h[0].remaining >= cost {
+		b := cs.h[0]
+		t := b.tok
+		if s.maxInFlight > 0 && t.inFlight >= s.maxInFlight {
+			saturated = true
+			busy = append(busy, heap.Pop(&cs.h).(*budget))
+			continue
+		}
+		if perMinute > 0 && t.window(category).sum(now)+points > perMinute {
+			saturated = true
+			busy = append(busy, heap.Pop(&cs.h).(*budget))
+			continue
+		}
+		b.remaining -= cost
+		b.lastPick = now
+		heap.Fix(&cs.h, b.index)
+		t.inFlight++
+		t.window(category).add(now, points)
+		return t.id, t.token, nil, nil
+	}
+	if saturated { return "", "", s.freed, nil }
+	retry := cs.nextReset
+	return "", "", nil, &ExhaustedError{Category: category, RetryAt: retry}
+}
+
+// release frees the slot pick reserved on a token.
+func (s *scheduler) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tokens[id]; ok && t.inFlight > 0 { t.inFlight-- }
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+// update applies a rate limit GitHub reported for a token.
+func (s *scheduler) update(id, category string, l limitCat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok { return }
+	t.limits[category] = limitSeen{l, time.Now()}
+	if cs, ok := s.cats[category]; ok { cs.reload(category, t) }
+}
+
+// observe records a successful response for a token, with the rate limit it
+// reported if any, and queues both for the next flush to Postgres.
+func (s *scheduler) observe(id, category string, l limitCat, ok bool) {
+	if ok { s.update(id, category, l) }
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, known := s.tokens[id]; !known { return }
+	if ok { s.unsaved[rateKey{id, category}] = limitSeen{l, now} }
+	s.okAt[id] = now
+}
+
+// unflushed hands over (and forgets) what observe queued.
+func (s *scheduler) unflushed() (map[rateKey]limitSeen, map[string]time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rates, oks := s.unsaved, s.okAt
+	s.unsaved, s.okAt = map[rateKey]limitSeen{}, map[string]time.Time{}
+	return rates, oks
+}
+
+// exhaust benches a token that GitHub rate limited: for one category until
+// its reset, or for every category after a secondary limit.
+func (s *scheduler) exhaust(id, category string, until time.Time, secondary bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok { return }
+	if secondary {
+		t.blockedUntil = until
+		for name, cs := range s.cats { cs.reload(name, t) }
+		return
+	}
+	seen := t.limits[category]
+	if seen.Limit == 0 { seen.Limit = defaultLimit(category) }
+	seen.Remaining, seen.at = 0, time.Now()
+	if until.After(seen.Reset) { seen.Reset = until }
+	t.limits[category] = seen
+	if cs, ok := s.cats[category]; ok { cs.reload(category, t) }
+}
+
+// remove drops a revoked token right away instead of waiting for the next sync.
+func (s *scheduler) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, id)
+	for _, cs := range s.cats {
+		if b, ok := cs.byToken[id]; ok {
+			heap.Remove(&cs.h, b.index)
+			delete(cs.byToken, id)
+		}
+	}
+}
+
+// budget sums known remaining and total requests in a category; windows that
+// have reset count as fully available.
+func (s *scheduler) budget(category string) (remaining, limit int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs := s.category(category)
+	cs.refill(time.Now())
+	for _, b := range cs.h {
+		if !b.known { continue }
+		remaining += int64(b.remaining)
+		limit += int64(b.limit)
+	}
+	return
+}
+
+// budgetHeap is a max-heap on remaining requests; ties go to the token picked least recently.
+type budgetHeap []*budget
+
+func (h budgetHeap) Len() int { return len(h) }
+func (h budgetHeap) Less(i, j int) bool {
+	if h[i].remaining != h[j].remaining { return h[i].remaining > h[j].remaining }
+	return h[i].lastPick.Before(h[j].lastPick)
+}
+func (h budgetHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *budgetHeap) Push(x any) {
+	b := x.(*budget)
+	b.index = len(*h)
+	*h = append(*h, b)
+}
+func (h *budgetHeap) Pop() any {
+	old := *h
+	b := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return b
+}
@@ -0,0 +1,188 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testScheduler is a loaded scheduler holding tokens "1", "2", ... with the
+// given core remaining (of 5000) each; other categories get defaultLimit.
+func testScheduler(maxInFlight, pointsPerMinute, graphqlPointsPerMinute int, remaining ...int) *scheduler {
+	s := newScheduler(nil, maxInFlight, pointsPerMinute, graphqlPointsPerMinute)
+	s.loaded = true
+	reset := time.Now().Add(time.Hour)
+	for i, rem := range remaining {
+		id := string(rune('1' + i))
+		seen := limitSeen{limitCat{Limit: 5000, Remaining: rem, Reset: reset}, time.Now()}
+		s.tokens[id] = &schedToken{id: id, token: "tok-" + id, limits: map[string]limitSeen{"core": seen}}
+	}
+	return s
+}
+
+func TestPointWindowRollsOver(t *testing.T) {
+	var w pointWindow
+	t0 := time.Unix(1_000_000, 0)
+	w.add(t0, 5)
+	w.add(t0.Add(500*time.Millisecond), 1)
+	if got := w.sum(t0); got != 6 { t.Fatalf("sum within one second = %d, want 6", got) }
+	w.add(t0.Add(30*time.Second), 10)
+	cases := []struct {
+		at time.Duration
+		want int
+	}{
+		{30 * time.Second, 16},
+		{59 * time.Second, 16},
+		// the first second has left the window
+		{60 * time.Second, 10},
+		{89 * time.Second, 10},
+		{90 * time.Second, 0},
+	}
+	for _, c := range cases {
+		if got := w.sum(t0.Add(c.at)); got != c.want { t.Errorf("sum at +%s = %d, want %d", c.at, got, c.want) }
+	}
+	// a minute later the same bucket starts over instead of adding to the old count
+	w.add(t0.Add(60*time.Second), 2)
+	if got := w.sum(t0.Add(60 * time.Second)); got != 12 { t.Errorf("sum after reusing a bucket = %d, want 12", got) }
+	if got := w.sum(t0.Add(2 * time.Minute)); got != 0 { t.Errorf("sum two minutes on = %d, want 0", got) }
+}
+
+func TestSchedulerGraphQLPointsSeparate(t *testing.T) {
+	s := testScheduler(0, 5, 2, 5000)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if _, _, err := s.pick(ctx, "core", 1, 1); err != nil { t.Fatalf("core pick %d: %v", i, err) }
+	}
+	// REST points are spent, GraphQL's are not
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.pick(ctx, "graphql", 1, 1); err != nil { t.Fatalf("graphql pick %d: %v", i, err) }
+	}
+	for _, cat := range []string{"core", "graphql"} {
+		if _, _, wait, _ := s.tryPick(cat, 1, 1); wait == nil { t.Errorf("%s: pick over the points cap didn't wait", cat) }
+	}
+}
+
+func TestSchedulerPickWaits(t *testing.T) {
+	s := testScheduler(1, 0, 0, 5000)
+	ctx := context.Background()
+	id, _, err := s.pick(ctx, "core", 1, 1)
+	if err != nil { t.Fatal(err) }
+	got := make(chan error, 1)
+	go func() {
+		_, _, err := s.pick(ctx, "core", 1, 1)
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("pick didn't wait for the busy token: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.release(id)
+	select {
+	case err := <-got:
+		if err != nil { t.Fatal(err) }
+	case <-time.After(time.Second):
+		t.Fatal("pick still waiting after release")
+	}
+}
+
+func TestSchedulerPickWaitTimesOut(t *testing.T) {
+	s := testScheduler(1, 0, 0, 5000)
+	s.maxWait = 50 * time.Millisecond
+	if _, _, err := s.pick(context.Background(), "core", 1, 1); err != nil { t.Fatal(err) }
+	start := time.Now()
+	_, _, err := s.pick(context.Background(), "core", 1, 1)
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Category != "core" { t.Fatalf("err = %v, want an *ExhaustedError", err) }
+	if d := time.Since(start); d > time.Second { t.Fatalf("gave up after %s", d) }
+	// a cancelled caller stops waiting too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.maxWait = time.Minute
+	if _, _, err := s.pick(ctx, "core", 1, 1); !errors.Is(err, context.Canceled) { t.Fatalf("err = %v, want context.Canceled", err) }
+}
+
+// picks returns the tokens n picks of cost land on, releasing each right away.
+func picks(t *testing.T, s *scheduler, category string, cost, n int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		id, _, err := s.pick(context.Background(), category, cost, 1)
+		if err != nil { t.Fatalf("pick %d: %v", i, err) }
+		s.release(id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestSchedulerPicksMostRemaining(t *testing.T) {
+	s := testScheduler(0, 0, 0, 3000, 5000, 4000)
+	// each pick charges its cost up front, so the order follows what's left
+	if got := fmt.Sprint(picks(t, s, "core", 1500, 4)); got != "[2 3 2 1]" { t.Fatalf("picks = %s, want [2 3 2 1]", got) }
+	if rem, _ := s.budget("core"); rem != 12000-4*1500 { t.Fatalf("core remaining = %d, want %d", rem, 12000-4*1500) }
+	// nobody can afford more than they have left
+	if _, _, err := s.pick(context.Background(), "core", 3500, 1); !errors.As(err, new(*ExhaustedError)) { t.Fatalf("err = %v, want an *ExhaustedError", err) }
+}
+
+func TestSchedulerTiesGoToLeastRecent(t *testing.T) {
+	s := testScheduler(0, 0, 0, 5000, 5000)
+	ids := picks(t, s, "core", 1, 4)
+	if ids[0] == ids[1] || ids[2] != ids[0] || ids[3] != ids[1] { t.Fatalf("picks = %v, want the two tokens taking turns", ids) }
+}
+
+func TestSchedulerMaxInFlight(t *testing.T) {
+	s := testScheduler(2, 0, 0, 5000, 4000)
+	var ids []string
+	for i := 0; i < 4; i++ {
+		id, _, err := s.pick(context.Background(), "core", 1, 1)
+		if err != nil { t.Fatal(err) }
+		ids = append(ids, id)
+	}
+	// token 1 has more budget but only two slots
+	if got := fmt.Sprint(ids); got != "[1 1 2 2]" { t.Fatalf("picks = %s, want [1 1 2 2]", got) }
+	if _, _, wait, err := s.tryPick("core", 1, 1); wait == nil { t.Fatalf("pick with every slot taken didn't wait (err %v)", err) }
+	// the cap is per token, not per category
+	if _, _, wait, _ := s.tryPick("search", 1, 1); wait == nil { t.Fatal("search pick ignored the concurrency cap") }
+	s.release("1")
+	if id, _, err := s.pick(context.Background(), "core", 1, 1); err != nil || id != "1" { t.Fatalf("pick after release = %q, %v; want token 1", id, err) }
+	// busy tokens go back on the heap: with 2 freed again it is picked as usual
+	s.release("2")
+	if id, _, err := s.pick(context.Background(), "core", 1, 1); err != nil || id != "2" { t.Fatalf("pick = %q, %v; want token 2", id, err) }
+}
+
+func TestSchedulerExhaustReorders(t *testing.T) {
+	s := testScheduler(0, 0, 0, 5000, 4000, 3000)
+	if got := picks(t, s, "core", 1, 1)[0]; got != "1" { t.Fatalf("first pick = %s, want 1", got) }
+	reset := time.Now().Add(time.Hour)
+	// a primary limit only benches the token for its category
+	s.exhaust("1", "core", reset, false)
+	if got := fmt.Sprint(picks(t, s, "core", 1, 2)); got != "[2 2]" { t.Fatalf("picks after exhausting 1 = %s, want [2 2]", got) }
+	s.update("1", "search", limitCat{Limit: 100, Remaining: 100, Reset: reset})
+	if got := picks(t, s, "search", 1, 1)[0]; got != "1" { t.Fatalf("search pick = %s, want 1", got) }
+	// a secondary limit benches it everywhere, including heaps built before it
+	s.exhaust("2", "core", time.Now().Add(time.Minute), true)
+	if got := fmt.Sprint(picks(t, s, "core", 1, 2)); got != "[3 3]" { t.Fatalf("picks after blocking 2 = %s, want [3 3]", got) }
+	for _, id := range picks(t, s, "search", 1, 6) {
+		if id == "2" { t.Fatal("search picked a blocked token") }
+	}
+	for _, id := range picks(t, s, "graphql", 1, 6) {
+		if id == "2" { t.Fatal("graphql picked a blocked token") }
+	}
+	// with every token out of core budget the error says when the first one resets
+	s.exhaust("3", "core", reset, false)
+	_, _, err := s.pick(context.Background(), "core", 1, 1)
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.RetryAt.IsZero() || exhausted.RetryAt.After(reset) { t.Fatalf("err = %v, want an *ExhaustedError retrying by %s", err, reset) }
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	s := testScheduler(0, 0, 0, 5000, 4000)
+	picks(t, s, "core", 1, 1)
+	s.remove("1")
+	for _, id := range picks(t, s, "core", 1, 3) {
+		if id != "2" { t.Fatalf("picked removed token %s", id) }
+	}
+	if rem, limit := s.budget("core"); rem != 3997 || limit != 5000 { t.Fatalf("budget = %d/%d, want 3997/5000", rem, limit) }
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"gh-proxy/internal/cache"
+)
+
+// Cache inspection and purge for admins (and API keys holding the "purge" permission).
+
+// upstreamURL accepts either a full GitHub API URL or a path like /repos/o/r.
+func (s *Server) upstreamURL(u string) string {
+	u = strings.TrimSpace(u)
+	if strings.HasPrefix(u, "/") { return s.cfg.GithubAPIURL + u }
+	return u
+}
+
+// Search cached_responses by URL prefix (default: everything, newest first)
+func (s *Server) handleAdminCacheJSON(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if x, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && x > 0 && x <= 1000 { limit = x }
+	entries, err := s.cache.Search(r.Context(), s.upstreamURL(r.URL.Query().Get("prefix")), limit)
+	if errors.Is(err, cache.ErrUnsupported) { http.Error(w, err.Error(), 501); return }
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+// One entry with headers, size, age and expiry
+func (s *Server) handleAdminCacheEntryJSON(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil { http.Error(w, "bad id", 400); return }
+	in, err := s.cache.Inspect(r.Context(), id)
+	if errors.Is(err, cache.ErrUnsupported) { http.Error(w, err.Error(), 501); return }
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	if in == nil { http.Error(w, "not found", 404); return }
+	out := map[string]any{"entry": in, "age_seconds": int64(time.Since(in.CreatedAt).Seconds())}
+	if in.ExpiresAt != nil { out["expires_in_seconds"] = int64(time.Until(*in.ExpiresAt).Seconds()) }
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) handleAdminCachePurge(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil { http.Error(w, err.Error(), 400); return }
+	if !s.checkCSRF(r) { http.Error(w, "bad csrf", 403); return }
+	s.purge(w, r, "admin")
+}
+
+// API keys with the "purge" permission: POST /cache/purge with X-API-Key
+func (s *Server) handleAPIKeyCachePurge(w http.ResponseWriter, r *http.Request) {
+	apiKey := parseAPIKey(r.Header.Get("X-API-Key"))
+	if apiKey == "" { http.Error(w, "missing X-API-Key", 401); return }
+	var disabled, canPurge bool
+	_ = s.pool.QueryRow(r.Context(), `SELECT disabled, 'purge' = ANY(permissions) FROM api_keys WHERE key_hash=$1`, sha256Hex(apiKey)).Scan(&disabled, &canPurge)
+	if disabled || !canPurge { http.Error(w, "api key lacks purge permission", 403); return }
+	if err := r.ParseForm(); err != nil { http.Error(w, err.Error(), 400); return }
+	s.purge(w, r, "key "+maskKey(apiKey))
+}
+
+// purge evicts by exact url=, by prefix=, or everything with all=true.
+func (s *Server) purge(w http.ResponseWriter, r *http.Request, who string) {
+	var n int64
+	var err error
+	var what string
+	ctx := context.WithoutCancel(r.Context())
+	switch {
+	case r.FormValue("url") != "":
+		what = s.upstreamURL(r.FormValue("url"))
+		n, err = s.cache.PurgeURL(ctx, what)
+	case r.FormValue("prefix") != "":
+		what = s.upstreamURL(r.FormValue("prefix")) + "*"
+		n, err = s.cache.PurgePrefix(ctx, s.upstreamURL(r.FormValue("prefix")))
+	case r.FormValue("all") == "true":
+		what = "everything"
+		n, err = s.cache.PurgeAll(ctx)
+	default:
+		http.Error(w, "one of url, prefix or all=true is required", 400); return
+	}
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	log.Printf("cache purge by %s: %s (%d rows)", who, what, n)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"purged": n})
+}
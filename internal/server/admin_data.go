@@ -17,7 +17,8 @@ SELECT k.id::text,
        k.total_requests AS total,
        CASE WHEN k.total_requests > 0 THEN (k.total_cached_requests::float / k.total_requests::float) * 100 ELSE 0 END AS hit_rate,
        k.last_used_at,
-       k.disabled
+       k.disabled,
+       k.permissions
 FROM api_keys k
 ORDER BY k.created_at DESC`)
 	if err != nil { http.Error(w, err.Error(), 500); return }
@@ -29,6 +30,7 @@ ORDER BY k.created_at DESC`)
 		HitRate float64 `json:"hit_rate"`
 		LastUsed *time.Time `json:"last_used"`
 		Disabled bool `json:"disabled"`
+		Permissions []string `json:"permissions"`
 	}
 	var out []row
 	for rows.Next() {
@@ -37,8 +39,9 @@ ORDER BY k.created_at DESC`)
 		var hitRate float64
 		var lastUsed *time.Time
 		var disabled bool
-		if err := rows.Scan(&id, &hc, &app, &machine, &hint, &total, &hitRate, &lastUsed, &disabled); err!=nil { http.Error(w, err.Error(), 500); return }
-		out = append(out, row{ID: id, Display: formatKeyDisplay(hc, app, machine, hint), Total: total, HitRate: hitRate, LastUsed: lastUsed, Disabled: disabled})
+		var perms []string
+		if err := rows.Scan(&id, &hc, &app, &machine, &hint, &total, &hitRate, &lastUsed, &disabled, &perms); err!=nil { http.Error(w, err.Error(), 500); return }
+		out = append(out, row{ID: id, Display: formatKeyDisplay(hc, app, machine, hint), Total: total, HitRate: hitRate, LastUsed: lastUsed, Disabled: disabled, Permissions: perms})
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
@@ -81,6 +84,7 @@ SELECT rl.method,
        rl.path,
        rl.status,
        rl.created_at,
+       COALESCE(rl.cache_rule,'') AS cache_rule,
        COALESCE(ak.hc_username||'_'||ak.app_name||'_'||ak.machine||CASE WHEN COALESCE(ak.key_hint,'')<>'' THEN '_'||ak.key_hint ELSE '' END,'') AS display
 FROM request_logs rl
 LEFT JOIN api_keys ak ON ak.key_hash = rl.api_key
@@ -88,9 +92,9 @@ ORDER BY rl.id DESC
 LIMIT 1000`)
 	if err != nil { http.Error(w, err.Error(), 500); return }
 	defer rows.Close()
-	type row struct{ Method string `json:"method"`; Path string `json:"path"`; Status int `json:"status"`; CreatedAt time.Time `json:"created_at"`; Display string `json:"display"` }
+	type row struct{ Method string `json:"method"`; Path string `json:"path"`; Status int `json:"status"`; CreatedAt time.Time `json:"created_at"`; Rule string `json:"rule"`; Display string `json:"display"` }
 	var out []row
-	for rows.Next() { var rr row; if err := rows.Scan(&rr.Method, &rr.Path, &rr.Status, &rr.CreatedAt, &rr.Display); err!=nil { http.Error(w, err.Error(), 500); return }; out = append(out, rr) }
+	for rows.Next() { var rr row; if err := rows.Scan(&rr.Method, &rr.Path, &rr.Status, &rr.CreatedAt, &rr.Rule, &rr.Display); err!=nil { http.Error(w, err.Error(), 500); return }; out = append(out, rr) }
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
 }
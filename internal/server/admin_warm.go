@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"gh-proxy/internal/warmer"
+)
+
+// Cache warming jobs: URL lists or templates refreshed on an interval by the warmer.
+
+func (s *Server) handleAdminWarmJSON(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.pool.Query(r.Context(), `SELECT id, name, paths, vals, interval_seconds, enabled, last_run_at, COALESCE(last_status,'') FROM warm_jobs ORDER BY id`)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	defer rows.Close()
+	type row struct {
+		ID int64 `json:"id"`
+		Name string `json:"name"`
+		Paths string `json:"paths"`
+		Values string `json:"values"`
+		URLs int `json:"urls"`
+		Interval int `json:"interval_seconds"`
+		Enabled bool `json:"enabled"`
+		LastRun *time.Time `json:"last_run_at"`
+		LastStatus string `json:"last_status"`
+	}
+	out := []row{}
+	for rows.Next() {
+		var rr row
+		if err := rows.Scan(&rr.ID, &rr.Name, &rr.Paths, &rr.Values, &rr.Interval, &rr.Enabled, &rr.LastRun, &rr.LastStatus); err != nil { http.Error(w, err.Error(), 500); return }
+		rr.URLs = len(warmer.Expand(rr.Paths, rr.Values))
+		out = append(out, rr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) handleAdminWarmCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil { http.Error(w, err.Error(), 400); return }
+	if !s.checkCSRF(r) { http.Error(w, "bad csrf", 403); return }
+	name := strings.TrimSpace(r.FormValue("name"))
+	paths := r.FormValue("paths")
+	values := r.FormValue("values")
+	if name == "" || len(warmer.Expand(paths, values)) == 0 { http.Error(w, "name and at least one path are required", 400); return }
+	interval := 3600
+	if x, err := strconv.Atoi(r.FormValue("interval")); err == nil && x >= 60 { interval = x }
+	_, err := s.pool.Exec(r.Context(), `INSERT INTO warm_jobs(name, paths, vals, interval_seconds) VALUES($1,$2,$3,$4)`, name, paths, values, interval)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	log.Printf("created warm job %q (%d urls every %ds)", name, len(warmer.Expand(paths, values)), interval)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (s *Server) handleAdminWarmDelete(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil { http.Error(w, err.Error(), 400); return }
+	if !s.checkCSRF(r) { http.Error(w, "bad csrf", 403); return }
+	id := mux.Vars(r)["id"]
+	_, err := s.pool.Exec(r.Context(), `DELETE FROM warm_jobs WHERE id::text=$1`, id)
+	if err != nil { http.Error(w, err.Error(), 500); return }
+	log.Printf("deleted warm job id=%s", id)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -30,7 +30,8 @@ func (s *Server) handleGitHubLogin(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   300,
 	})
 	u := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=read:user&state=%s",
+		"%s/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=read:user&state=%s",
+		s.cfg.GithubWebURL,
 		url.QueryEscape(s.cfg.GithubClientID),
 		url.QueryEscape(redir),
 		url.QueryEscape(state),
@@ -69,7 +70,7 @@ func (s *Server) handleGitHubCallback(w http.ResponseWriter, r *http.Request) {
 		"client_secret": {s.cfg.GithubClientSecret},
 		"code":          {code},
 	}
-	req, _ := http.NewRequest("POST", "https://github.com/login/oauth/access_token", strings.NewReader(form.Encode()))
+	req, _ := http.NewRequest("POST", s.cfg.GithubWebURL+"/login/oauth/access_token", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	client := &http.Client{Timeout: 15 * time.Second}
@@ -94,7 +95,7 @@ func (s *Server) handleGitHubCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req2, _ := http.NewRequest("GET", "https://api.github.com/user", nil)
+	req2, _ := http.NewRequest("GET", s.cfg.GithubAPIURL+"/user", nil)
 	req2.Header.Set("Accept", "application/vnd.github+json")
 	req2.Header.Set("Authorization", "Bearer "+tok.AccessToken)
 	uresp, err := client.Do(req2)
@@ -119,5 +120,6 @@ func (s *Server) handleGitHubCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("oauth: token stored for @%s", user.Login)
+	s.gh.TokensChanged()
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
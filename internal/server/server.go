@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"math"
 	"log"
 	"net"
 	"net/http"
@@ -30,12 +31,14 @@ import (
 
 	"gh-proxy/internal/cache"
 	"gh-proxy/internal/config"
+	"gh-proxy/internal/db"
 	gh "gh-proxy/internal/github"
+	"gh-proxy/internal/warmer"
 )
 
 type Server struct {
 	Router *mux.Router
-	pool *pgxpool.Pool
+	pool db.DB
 	cfg config.Config
 	cache *cache.Cache
 	gh *gh.Client
@@ -47,21 +50,28 @@ type Server struct {
 	tmpl *template.Template
 	// rate limiting
 	ratelimit *rateLimiter
+	// in-flight upstream fetches, for coalescing identical misses
+	inflight *cache.Flight[upstreamResult]
 }
 
 func New(pool *pgxpool.Pool, cfg config.Config) *Server {
 	s := &Server{
 		pool: pool,
 		cfg: cfg,
-		cache: cache.New(pool, cfg.MaxCacheTime.Duration(), cfg.MaxCacheSizeMB),
-		gh: gh.New(pool),
+		cache: cache.New(pool, cfg),
+		gh: gh.New(pool, cfg),
 		hub: newWSHub(),
 		ratelimit: newRateLimiter(),
+		inflight: cache.NewFlight[upstreamResult](),
 	}
 	s.u = upgrader{Upgrader: websocket.Upgrader{CheckOrigin: s.checkWebsocketOrigin}}
 	s.tmpl = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
 	go s.hub.run()
 	go s.cacheJanitor()
+	go s.cacheReclaimer()
+	go warmer.New(pool, s.cache, s.gh, cfg).Run()
+	go s.gh.RateRefresher()
+	go s.gh.TokenSync()
 
 	r := mux.NewRouter()
 	r.Use(s.requestLogger)
@@ -81,9 +91,18 @@ func New(pool *pgxpool.Pool, cfg config.Config) *Server {
 	ar.HandleFunc("/keys.json", s.handleAdminKeysJSON).Methods("GET")
 	ar.HandleFunc("/keys_usage.json", s.handleAdminKeysUsageJSON).Methods("GET")
 	ar.HandleFunc("/recent.json", s.handleAdminRecentJSON).Methods("GET")
+	ar.HandleFunc("/cache.json", s.handleAdminCacheJSON).Methods("GET")
+	ar.HandleFunc("/cache/{id:[0-9]+}.json", s.handleAdminCacheEntryJSON).Methods("GET")
+	ar.HandleFunc("/cache/purge", s.handleAdminCachePurge).Methods("POST")
+	ar.HandleFunc("/warm.json", s.handleAdminWarmJSON).Methods("GET")
+	ar.HandleFunc("/warm", s.handleAdminWarmCreate).Methods("POST")
+	ar.HandleFunc("/warm/{id}/delete", s.handleAdminWarmDelete).Methods("POST")
 
-	r.HandleFunc("/gh/{rest:.*}", s.handleProxyREST)
+	r.HandleFunc("/cache/purge", s.handleAPIKeyCachePurge).Methods("POST")
+
+	// graphql first: the catch-all would otherwise take it and miss GHES's /api/graphql
 	r.HandleFunc("/gh/graphql", s.handleProxyGraphQL)
+	r.HandleFunc("/gh/{rest:.*}", s.handleProxyREST)
 
 	s.Router = r
 	return s
@@ -98,6 +117,18 @@ func (s *Server) cacheJanitor() {
 	}
 }
 
+// cacheReclaimer periodically VACUUMs the cache table so space freed by eviction is reused.
+func (s *Server) cacheReclaimer() {
+	every := time.Duration(s.cfg.CacheVacuumInterval.Duration()) * time.Second
+	if every <= 0 { return }
+	for {
+		time.Sleep(every)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if err := s.cache.Reclaim(ctx); err != nil { log.Printf("cache: vacuum: %v", err) }
+		cancel()
+	}
+}
+
 func (s *Server) basicAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, pass, ok := r.BasicAuth()
@@ -119,7 +150,7 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	var lastAt *time.Time
 	_ = s.pool.QueryRow(r.Context(), `SELECT COUNT(*) FROM donated_tokens WHERE revoked=false`).Scan(&donors)
 	_ = s.pool.QueryRow(r.Context(), `SELECT github_user, created_at FROM donated_tokens WHERE revoked=false ORDER BY created_at DESC LIMIT 1`).Scan(&lastUser, &lastAt)
-	if lastUser != "" { lastURL = "https://github.com/" + lastUser }
+	if lastUser != "" { lastURL = s.cfg.GithubWebURL + "/" + lastUser }
 	if lastAt != nil { lastAgo = humanizeDuration(time.Since(*lastAt)) }
 	data := map[string]any{
 		"Donors": donors,
@@ -188,7 +219,9 @@ func (s *Server) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
 	if i := strings.LastIndex(key, "_"); i >= 0 && i+1 < len(key) { randSeg = key[i+1:] }
 	hint := randSeg
 	if len(hint) > 6 { hint = hint[:6] }
-	_, err := s.pool.Exec(r.Context(), `INSERT INTO api_keys(key_hash,key_hint,hc_username,app_name,machine,rate_limit_per_sec) VALUES($1,$2,$3,$4,$5,$6)`, keyHash, hint, hc, app, machine, per)
+	perms := []string{}
+	if r.FormValue("perm_purge") != "" { perms = append(perms, "purge") }
+	_, err := s.pool.Exec(r.Context(), `INSERT INTO api_keys(key_hash,key_hint,hc_username,app_name,machine,rate_limit_per_sec,permissions) VALUES($1,$2,$3,$4,$5,$6,$7)`, keyHash, hint, hc, app, machine, per, perms)
 	if err != nil { http.Error(w, err.Error(), 500); return }
 	log.Printf("created api key for %s/%s on %s: %s", hc, app, machine, maskKey(key))
 	// Show the key once to the admin immediately
@@ -208,11 +241,11 @@ func (s *Server) handleDisableAPIKey(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleProxyREST(w http.ResponseWriter, r *http.Request) {
-	s.serveProxy(w, r, "https://api.github.com/"+mux.Vars(r)["rest"])
+	s.serveProxy(w, r, s.cfg.GithubAPIURL+"/"+mux.Vars(r)["rest"])
 }
 
 func (s *Server) handleProxyGraphQL(w http.ResponseWriter, r *http.Request) {
-	s.serveProxy(w, r, "https://api.github.com/graphql")
+	s.serveProxy(w, r, s.cfg.GithubGraphQLURL())
 }
 
 func (s *Server) serveProxy(w http.ResponseWriter, r *http.Request, target string) {
@@ -238,64 +271,329 @@ func (s *Server) serveProxy(w http.ResponseWriter, r *http.Request, target strin
 
 	fullTarget := targetWithQuery(target, r.URL.RawQuery)
 
+	keyBody := body
+	pc := proxyCall{apiKeyHash: apiKeyHash, target: fullTarget, category: gh.CategoryFor(fullTarget)}
 	cacheable := r.Method == http.MethodGet || r.Method == http.MethodHead
-	// Try cache first (GET/HEAD only)
+	isGraphQL := r.Method == http.MethodPost && pc.category == "graphql"
+	if isGraphQL && s.cfg.CacheGraphQL {
+		// read-only queries are keyed on their normalized query+variables; mutations pass through
+		if norm, ok := cache.NormalizeGraphQL(body); ok { keyBody, cacheable = norm, true }
+	}
+	// canonical key: sorted query, no-op params dropped, Accept/API version folded in
+	key := cache.NewKey(r.Method, fullTarget, keyBody, r.Header)
+	// per-endpoint TTL rules decide how long (and whether) to cache
+	pol := s.cache.PolicyFor(pc.category, r.Method, gh.APIPath(target))
+	if cacheable { pc.rule = pol.Rule }
+	cacheable = cacheable && pol.Store
+	// client Cache-Control: no-cache / max-age force revalidation, no-store skips storing,
+	// only-if-cached never spends a token
+	dir := cache.ParseDirectives(r.Header)
+	store := cacheable && !dir.NoStore
+	// Try cache first (GET/HEAD, opted-in GraphQL queries); keep an unusable entry around for revalidation
+	var stale *cache.Entry
 	if cacheable {
-		if status, hdrJSON, cached, hit, err := s.cache.Get(r.Context(), r.Method, fullTarget, body); err == nil && hit {
-			wHeaderFromJSON(w.Header(), hdrJSON)
-			// add debug headers
-			w.Header().Set("X-Gh-Proxy-Cache", "hit")
-			w.Header().Set("X-Gh-Proxy-Category", ghCategory(fullTarget))
-			if disp := s.lookupClientDisplay(r.Context(), apiKeyHash); disp != "" { w.Header().Set("X-Gh-Proxy-Client", disp) }
-			w.WriteHeader(status)
-			_, _ = w.Write(cached)
-			s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, status, true)
+		if e, err := s.cache.Lookup(r.Context(), key); err == nil && e != nil {
+			if dir.Accepts(e) {
+				s.serveCached(w, r, pc, e, "hit")
+				return
+			}
+			stale = e
+		}
+	}
+	// stale-while-revalidate applies to expired entries the client would still take
+	serveStale := stale != nil && !stale.Fresh() && !dir.NoCache && dir.AgeOK(stale) && s.cache.ServeStale(stale)
+	if dir.OnlyIfCached {
+		if serveStale {
+			s.serveCached(w, r, pc, stale, "stale")
 			return
 		}
+		http.Error(w, "not cached (only-if-cached)", http.StatusGatewayTimeout)
+		s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, http.StatusGatewayTimeout, false, pc.rule)
+		return
 	}
 
-	// Fetch from GitHub and cache
-	status, hdr, respBody, usedToken, err := s.gh.Do(r.Context(), r.Method, fullTarget, body)
-	if err != nil { log.Println("proxy error:", err) }
-	// Cache successful, cacheable responses (GitHub API responses are safe to cache even if private)
-	if cacheable && status == http.StatusOK {
-		// Skip caching only if explicitly no-cache or no-store
-		if cc := strings.ToLower(hdr.Get("Cache-Control")); !strings.Contains(cc, "no-cache") && !strings.Contains(cc, "no-store") {
-			hdrJSON, _ := json.Marshal(hdr)
-			_ = s.cache.Put(r.Context(), r.Method, fullTarget, body, status, hdrJSON, respBody)
+	// Fetch from GitHub (conditionally when we hold an expired copy) and cache.
+	// Concurrent identical misses share one upstream call; followers see "coalesced".
+	// forward the client's content negotiation instead of forcing the JSON media type
+	fwd := http.Header{}
+	for _, h := range cache.VaryHeaders {
+		if v := r.Header.Values(h); len(v) > 0 { fwd[http.CanonicalHeaderKey(h)] = v }
+	}
+	var cond http.Header
+	if stale != nil { cond = stale.ConditionalHeaders() }
+	for k, v := range cond { fwd[k] = v }
+	method := r.Method
+	// detach from the client so a disconnect doesn't fail followers or background refreshes
+	bg := context.WithoutCancel(r.Context())
+	// fetch streams the upstream body to w (when given and the response goes to
+	// the client as is). When the response may be stored it also keeps a copy of
+	// up to MAX_CACHEABLE_BYTES for the cache and coalesced followers; bigger
+	// bodies pass through uncached, and share tells followers so right away.
+	fetch := func(w http.ResponseWriter, share func(upstreamResult)) upstreamResult {
+		res := upstreamResult{}
+		var stream io.ReadCloser
+		res.status, res.hdr, stream, res.token, res.err = s.gh.Stream(bg, method, fullTarget, body, fwd)
+		if res.err != nil { log.Println("proxy error:", res.err) }
+		if stream != nil {
+			var buf *cappedBuffer // nil: nothing will be cached, don't keep the body
+			if store && s.cfg.MaxCacheableBytes > 0 {
+				buf = &cappedBuffer{max: s.cfg.MaxCacheableBytes}
+				if share != nil {
+					status := res.status
+					buf.onOver = func() { share(upstreamResult{status: status, incomplete: true}) }
+				}
+				if n, err := strconv.ParseInt(res.hdr.Get("Content-Length"), 10, 64); err == nil && n > buf.max { buf.fail() }
+			}
+			if w != nil && s.streamable(res, stale) {
+				s.writeUpstreamHead(w, r, pc, res, "miss")
+				res.streamed = true
+				if err := copyStreaming(w, stream, buf); err != nil {
+					log.Println("proxy stream error:", err)
+					if buf != nil { buf.fail() }
+					res.truncated = true
+				}
+			} else if buf != nil {
+				if err := buf.fill(stream); err != nil { buf.fail() }
+			}
+			stream.Close()
+			res.incomplete = true
+			if buf != nil { res.body, res.incomplete = buf.b, buf.over }
 		}
+		if stale != nil && cond != nil && res.status == http.StatusNotModified {
+			// 304s don't count against the token's rate limit; push our copy's expiry out
+			ttl := pol.TTL
+			if negTTL, ok := s.cache.NegativeTTL(stale.Status); ok { ttl = negTTL }
+			if err := s.cache.Refresh(bg, stale, ttl); err != nil { log.Println("cache refresh error:", err) }
+		}
+		// Cache successful, cacheable responses (GitHub API responses are safe to cache even if private)
+		ttl := pol.TTL
+		storable := res.status == http.StatusOK
+		if negTTL, ok := s.cache.NegativeTTL(res.status); ok && !isGraphQL {
+			// negative caching: deleted repos / renamed users are asked for over and over
+			ttl, storable = negTTL, true
+		}
+		if store && storable && !res.incomplete && (!isGraphQL || cache.GraphQLCacheable(res.body)) {
+			// Skip caching only if explicitly no-cache or no-store
+			if cache.UpstreamStorable(res.hdr) {
+				hdrJSON, _ := json.Marshal(res.hdr)
+				_ = s.cache.Put(bg, key, body, res.status, hdrJSON, res.body, ttl)
+			}
+		}
+		return res
+	}
+	flightKey := key.String()
+	share := func(v upstreamResult) { s.inflight.Publish(flightKey, v) }
+	if serveStale && store {
+		// stale-while-revalidate: answer now, refresh behind the client's back.
+		// Not for no-store: the refresh would spend a token on a body nobody keeps.
+		go func() { _, _, _ = s.inflight.Do(bg, flightKey, func() upstreamResult { return fetch(nil, share) }) }()
+		s.serveCached(w, r, pc, stale, "stale")
+		return
+	}
+	var res upstreamResult
+	leader := true
+	if store {
+		var err error
+		res, leader, err = s.inflight.Do(r.Context(), flightKey, func() upstreamResult { return fetch(w, share) })
+		if err != nil { return } // client went away while waiting on the leader
+		if !leader && (res.incomplete || res.status == http.StatusNotModified && stale == nil) {
+			// the leader's body was too big to share, or it revalidated a copy we never saw; fetch our own
+			res, leader = fetch(w, nil), true
+		}
+	} else {
+		res = fetch(w, nil)
+	}
+	if leader && res.streamed {
+		s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, res.status, false, pc.rule)
+		// cut the connection so the client can't mistake a partial body for the whole
+		if res.truncated { panic(http.ErrAbortHandler) }
+		return
+	}
+	if stale != nil && res.status == http.StatusNotModified {
+		// GitHub just confirmed our copy, so its age starts over
+		confirmed := *stale
+		confirmed.CreatedAt = time.Now()
+		s.serveCached(w, r, pc, &confirmed, map[bool]string{true: "revalidated", false: "coalesced"}[leader])
+		return
+	}
+	if stale != nil && upstreamFailed(res) && s.cache.ServeStaleOnError(stale) {
+		// stale-if-error: timeouts, 5xx and an empty token pool fall back to our last copy
+		s.serveCached(w, r, pc, stale, "stale-error")
+		return
+	}
+	var exhausted *gh.ExhaustedError
+	if res.status == 0 && errors.As(res.err, &exhausted) {
+		// every donated token is out of budget for this category
+		if !exhausted.RetryAt.IsZero() { w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(time.Until(exhausted.RetryAt).Seconds())), 10)) }
+		http.Error(w, "all donated tokens are rate limited, try again later", http.StatusTooManyRequests)
+		s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, http.StatusTooManyRequests, false, pc.rule)
+		return
+	}
+	if res.status == 0 {
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, http.StatusBadGateway, false, pc.rule)
+		return
 	}
 
-	wHeaderCopy(w.Header(), hdr)
+	s.writeUpstreamHead(w, r, pc, res, map[bool]string{true: "miss", false: "coalesced"}[leader])
+	_, _ = w.Write(res.body)
+
+	// a coalesced response cost no token, so it counts as a cache hit
+	s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, res.status, !leader, pc.rule)
+}
+
+func upstreamFailed(res upstreamResult) bool { return res.err != nil || res.status == 0 || res.status >= 500 }
+
+// streamable reports whether an upstream response goes to the client as is,
+// rather than being answered from our cached copy (a 304, or stale-if-error).
+func (s *Server) streamable(res upstreamResult, stale *cache.Entry) bool {
+	if res.status == 0 { return false }
+	if stale == nil { return true }
+	if res.status == http.StatusNotModified { return false }
+	return !(upstreamFailed(res) && s.cache.ServeStaleOnError(stale))
+}
+
+// writeUpstreamHead copies GitHub's response headers, adds the debug headers and writes the status.
+func (s *Server) writeUpstreamHead(w http.ResponseWriter, r *http.Request, pc proxyCall, res upstreamResult, label string) {
+	wHeaderCopy(w.Header(), res.hdr)
 	// annotate debug headers
-	w.Header().Set("X-Gh-Proxy-Cache", "miss")
-	w.Header().Set("X-Gh-Proxy-Category", ghCategory(fullTarget))
-	if disp := s.lookupClientDisplay(r.Context(), apiKeyHash); disp != "" { w.Header().Set("X-Gh-Proxy-Client", disp) }
-	if usedToken != "" {
+	w.Header().Set("X-Gh-Proxy-Cache", label)
+	w.Header().Set("Age", "0")
+	w.Header().Set("X-Gh-Proxy-Cache-Age", "0")
+	w.Header().Set("X-Gh-Proxy-Category", pc.category)
+	if pc.rule != "" { w.Header().Set("X-Gh-Proxy-Cache-Rule", pc.rule) }
+	if disp := s.lookupClientDisplay(r.Context(), pc.apiKeyHash); disp != "" { w.Header().Set("X-Gh-Proxy-Client", disp) }
+	if res.token != "" {
 		var user string
-		_ = s.pool.QueryRow(r.Context(), `SELECT github_user FROM donated_tokens WHERE id::text=$1`, usedToken).Scan(&user)
+		_ = s.pool.QueryRow(r.Context(), `SELECT github_user FROM donated_tokens WHERE id::text=$1`, res.token).Scan(&user)
 		if user != "" { w.Header().Set("X-Gh-Proxy-Donor", user) }
 	}
-	w.WriteHeader(status)
-	_, _ = w.Write(respBody)
+	w.WriteHeader(res.status)
+}
+
+// how long one write to a streaming client may take; pushed out after every chunk
+const streamWriteTimeout = 30 * time.Second
+
+// copyStreaming copies an upstream body to the client as it arrives, keeping a
+// copy in buf unless it is nil. The write deadline moves forward with every
+// chunk so long downloads outlive the server's WriteTimeout. If the client goes
+// away the upstream body is still read for as long as buf wants it.
+func copyStreaming(w http.ResponseWriter, src io.Reader, buf *cappedBuffer) error {
+	rc := http.NewResponseController(w)
+	p := make([]byte, 32<<10)
+	clientGone := false
+	for {
+		n, err := src.Read(p)
+		if n > 0 {
+			if buf != nil { _, _ = buf.Write(p[:n]) }
+			if !clientGone {
+				_ = rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if _, werr := w.Write(p[:n]); werr != nil { clientGone = true }
+			}
+			if clientGone && (buf == nil || buf.over) { return nil }
+		}
+		if err == io.EOF { return nil }
+		if err != nil { return err }
+	}
+}
+
+// cappedBuffer holds a response body for the cache and coalesced followers
+// until it grows past max, then lets go of it and calls onOver (if set).
+type cappedBuffer struct {
+	max int64
+	b []byte
+	over bool
+	onOver func()
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.over { return len(p), nil }
+	if int64(len(c.b)+len(p)) > c.max {
+		c.fail()
+		return len(p), nil
+	}
+	c.b = append(c.b, p...)
+	return len(p), nil
+}
 
-	s.afterRequest(r.Context(), apiKeyHash, r.Method, r.URL.Path, status, false)
+// fill reads src into the buffer, stopping as soon as it is known to be too big.
+func (c *cappedBuffer) fill(src io.Reader) error {
+	_, err := io.Copy(c, io.LimitReader(src, c.max+1))
+	return err
 }
 
-func (s *Server) afterRequest(ctx context.Context, apiKeyHash, method, path string, status int, hit bool) {
+// fail drops the buffered body, e.g. when it is too big or the upstream read broke off.
+func (c *cappedBuffer) fail() {
+	if c.over { return }
+	c.b, c.over = nil, true
+	if c.onOver != nil { c.onOver() }
+}
+
+// proxyCall carries the per-request values the proxy helpers need.
+type proxyCall struct {
+	apiKeyHash string
+	target string // full upstream URL including query
+	category string
+	rule string // matched cache rule; empty for methods that are never cached
+}
+
+// upstreamResult is one GitHub response, shared between coalesced requests.
+type upstreamResult struct {
+	status int
+	hdr http.Header
+	body []byte // nil when incomplete
+	token string
+	err error
+	// incomplete: the body was too big to keep (or broke off), so followers fetch their own
+	incomplete bool
+	// streamed: the leader already wrote the response to its client; truncated: GitHub broke off mid-body
+	streamed, truncated bool
+}
+
+// serveCached writes a stored response, labelling how it was obtained in X-Gh-Proxy-Cache.
+func (s *Server) serveCached(w http.ResponseWriter, r *http.Request, pc proxyCall, e *cache.Entry, label string) {
+	wHeaderFromJSON(w.Header(), e.Headers)
+	// add debug headers
+	w.Header().Set("X-Gh-Proxy-Cache", label)
+	age := strconv.FormatInt(int64(e.Age().Seconds()), 10)
+	w.Header().Set("Age", age)
+	w.Header().Set("X-Gh-Proxy-Cache-Age", age)
+	w.Header().Set("X-Gh-Proxy-Category", pc.category)
+	if pc.rule != "" { w.Header().Set("X-Gh-Proxy-Cache-Rule", pc.rule) }
+	if disp := s.lookupClientDisplay(r.Context(), pc.apiKeyHash); disp != "" { w.Header().Set("X-Gh-Proxy-Client", disp) }
+	body := e.Body
+	if e.Encoding == cache.EncodingGzip {
+		// hand gzip-capable clients the stored bytes as-is; inflate for everyone else
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+		} else if b, err := e.DecodedBody(); err == nil {
+			body = b
+		} else {
+			log.Println("cache decode error:", err)
+			http.Error(w, "corrupt cache entry", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(e.Status)
+	_, _ = w.Write(body)
+	s.afterRequest(r.Context(), pc.apiKeyHash, r.Method, r.URL.Path, e.Status, true, pc.rule)
+}
+
+func (s *Server) afterRequest(ctx context.Context, apiKeyHash, method, path string, status int, hit bool, rule string) {
 	if hit { s.cacheHits.Add(1) }
 	s.totalReq.Add(1)
-	s.logRequest(ctx, apiKeyHash, method, path, status, hit)
+	s.logRequest(ctx, apiKeyHash, method, path, status, hit, rule)
 	log.Printf("%s %s -> %d (%s)", method, path, status, map[bool]string{true:"cache", false:"origin"}[hit])
-	s.hub.broadcastRecent(map[string]any{"method":method, "path":path, "created_at": time.Now(), "display": s.lookupClientDisplay(ctx, apiKeyHash)})
+	s.hub.broadcastRecent(map[string]any{"method":method, "path":path, "created_at": time.Now(), "display": s.lookupClientDisplay(ctx, apiKeyHash), "rule": rule})
 	s.hub.broadcastStat(s.stats())
 }
 
-func (s *Server) logRequest(ctx context.Context, apiKeyHash, method, path string, status int, hit bool) {
-	_, _ = s.pool.Exec(ctx, `INSERT INTO request_logs(api_key,method,path,status,cache_hit) VALUES($1,$2,$3,$4,$5)`, apiKeyHash, method, path, status, hit)
+func (s *Server) logRequest(ctx context.Context, apiKeyHash, method, path string, status int, hit bool, rule string) {
+	_, _ = s.pool.Exec(ctx, `INSERT INTO request_logs(api_key,method,path,status,cache_hit,cache_rule) VALUES($1,$2,$3,$4,$5,NULLIF($6,''))`, apiKeyHash, method, path, status, hit, rule)
 	
 	// Update cumulative stats - system level
-	s.updateSystemStats(ctx, hit)
+	s.updateSystemStats(ctx, hit, hit && cache.IsNegative(status))
 	
 	// Update cumulative stats - per API key level
 	if hit {
@@ -317,27 +615,31 @@ func (s *Server) LogsJanitor() {
 	}
 }
 
-// updateSystemStats increments system-wide cumulative counters and handles daily reset
-func (s *Server) updateSystemStats(ctx context.Context, hit bool) {
+// updateSystemStats increments system-wide cumulative counters and handles daily reset.
+// negative marks a cache hit on a stored 404/410/451.
+func (s *Server) updateSystemStats(ctx context.Context, hit, negative bool) {
 	// Use NYC Eastern Time for daily reset as requested
 	loc, _ := time.LoadLocation("America/New_York")
 	currentDate := time.Now().In(loc).Format("2006-01-02")
 	
 	if hit {
 		// Increment both total requests and cached requests
+		neg := 0
+		if negative { neg = 1 }
 		_, _ = s.pool.Exec(ctx, `
-			INSERT INTO system_stats (id, total_requests, total_cached_requests, today_requests, today_date) 
-			VALUES (1, 1, 1, 1, $1::date)
+			INSERT INTO system_stats (id, total_requests, total_cached_requests, total_negative_cached_requests, today_requests, today_date) 
+			VALUES (1, 1, 1, $2, 1, $1::date)
 			ON CONFLICT (id) DO UPDATE SET
 				total_requests = system_stats.total_requests + 1,
 				total_cached_requests = system_stats.total_cached_requests + 1,
+				total_negative_cached_requests = system_stats.total_negative_cached_requests + $2,
 				today_requests = CASE 
 					WHEN system_stats.today_date = $1::date THEN system_stats.today_requests + 1
 					ELSE 1
 				END,
 				today_date = $1::date,
 				updated_at = now()
-		`, currentDate)
+		`, currentDate, neg)
 	} else {
 		// Increment only total requests
 		_, _ = s.pool.Exec(ctx, `
@@ -359,11 +661,11 @@ func (s *Server) stats() map[string]any {
 	ctx := context.Background()
 	
 	// Get cumulative stats from system_stats table
-	var totalRequests, totalCached, todayRequests int64
+	var totalRequests, totalCached, totalNegative, todayRequests int64
 	_ = s.pool.QueryRow(ctx, `
-		SELECT total_requests, total_cached_requests, today_requests 
+		SELECT total_requests, total_cached_requests, total_negative_cached_requests, today_requests 
 		FROM system_stats WHERE id = 1
-	`).Scan(&totalRequests, &totalCached, &todayRequests)
+	`).Scan(&totalRequests, &totalCached, &totalNegative, &todayRequests)
 	
 	// Calculate cache hit rate from cumulative stats
 	var hitPct float64
@@ -374,11 +676,20 @@ func (s *Server) stats() map[string]any {
 	var activeDonated int64
 	_ = s.pool.QueryRow(ctx, `SELECT count(*) FROM donated_tokens WHERE revoked=false`).Scan(&activeDonated)
 	
+	// in-process L1 counters (since this instance started)
+	cs := s.cache.Stats()
+	
 	return map[string]any{
 		"totalRequests": totalRequests,
 		"cacheHitRate": fmt.Sprintf("%.1f%%", hitPct),
 		"today": todayRequests,
 		"activeTokens": activeDonated,
+		"negativeHits": totalNegative,
+		"memHits": cs.MemHits,
+		"memMisses": cs.MemMisses,
+		"memHitRate": fmt.Sprintf("%.1f%%", percent(cs.MemHits, cs.MemHits+cs.MemMisses)),
+		"memSize": fmt.Sprintf("%d entries / %.1f MB", cs.MemEntries, float64(cs.MemBytes)/1024/1024),
+		"cacheSize": fmt.Sprintf("%.1f / %d MB", float64(cs.StoredBytes)/1024/1024, s.cfg.MaxCacheSizeMB),
 	}
 }
 
@@ -398,11 +709,15 @@ func (s *Server) render(w http.ResponseWriter, name string, data any) {
 
 func targetWithQuery(target, raw string) string { if raw=="" { return target }; if strings.Contains(target, "?") { return target+"&"+raw }; return target+"?"+raw }
 
-func ghCategory(u string) string {
-	if strings.Contains(u, "/graphql") { return "graphql" }
-	if strings.Contains(u, "/search/code") { return "code_search" }
-	if strings.Contains(u, "/search/") { return "search" }
-	return "core"
+// acceptsGzip reports whether the client's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") { continue }
+		q := strings.ReplaceAll(strings.ToLower(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
 }
 
 func wHeaderCopy(dst http.Header, src http.Header) {
@@ -508,6 +823,9 @@ func (l *loggingResponseWriter) WriteHeader(code int) {
 	l.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the connection (write deadlines for streamed responses)
+func (l *loggingResponseWriter) Unwrap() http.ResponseWriter { return l.ResponseWriter }
+
 // Ensure websocket upgrades work through our wrapper
 func (l *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hj, ok := l.ResponseWriter.(http.Hijacker); ok {
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gh-proxy/internal/cache"
+	"gh-proxy/internal/config"
+	"gh-proxy/internal/db/dbtest"
+	"gh-proxy/internal/fakegithub"
+	gh "gh-proxy/internal/github"
+)
+
+// testServer is a proxy on the memory cache backend in front of a fake GitHub
+// with one donated token, "tok-a" (id "1"). Every API key is enabled.
+type testServer struct {
+	*Server
+	fake *fakegithub.Server
+	db *dbtest.DB
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	fake := fakegithub.New(5000, time.Hour)
+	fake.AddToken("tok-a", "donor")
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	d := dbtest.New()
+	d.On("SELECT disabled, rate_limit_per_sec", []any{false, 1000})
+	d.On("FROM donated_tokens d LEFT JOIN token_rate_limits", []any{"1", "tok-a"})
+	cfg := config.Config{GithubAPIURL: srv.URL, CacheBackend: "memory", MaxCacheableBytes: 1 << 20}
+	cfg.MaxCacheTime.Seconds = 300
+	s := &Server{
+		pool: d,
+		cfg: cfg,
+		cache: cache.New(nil, cfg),
+		gh: gh.New(d, cfg),
+		hub: newWSHub(),
+		ratelimit: newRateLimiter(),
+		inflight: cache.NewFlight[upstreamResult](),
+	}
+	go s.hub.run()
+	return &testServer{Server: s, fake: fake, db: d}
+}
+
+// get proxies GET path with the given request headers (name, value pairs).
+func (ts *testServer) get(path string, hdr ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/gh"+path, nil)
+	r.Header.Set("X-API-Key", "test-key")
+	for i := 0; i+1 < len(hdr); i += 2 { r.Header.Set(hdr[i], hdr[i+1]) }
+	w := httptest.NewRecorder()
+	ts.serveProxy(w, r, ts.cfg.GithubAPIURL+path)
+	return w
+}
+
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int, label string) {
+	t.Helper()
+	if w.Code != status || w.Header().Get("X-Gh-Proxy-Cache") != label {
+		t.Fatalf("got %d %q, want %d %q: %s", w.Code, w.Header().Get("X-Gh-Proxy-Cache"), status, label, w.Body)
+	}
+}
+
+func TestProxyMissThenHit(t *testing.T) {
+	ts := newTestServer(t)
+	miss := ts.get("/repos/o/r")
+	expect(t, miss, 200, "miss")
+	hit := ts.get("/repos/o/r")
+	expect(t, hit, 200, "hit")
+	if hit.Body.String() != miss.Body.String() { t.Fatalf("hit body %q, want %q", hit.Body, miss.Body) }
+	if n := ts.fake.Requests("tok-a"); n != 1 { t.Fatalf("%d upstream requests, want 1", n) }
+}
+
+func TestProxyNoCache(t *testing.T) {
+	ts := newTestServer(t)
+	miss := ts.get("/repos/o/r")
+	expect(t, miss, 200, "miss")
+	// no-cache sends our ETag upstream; the 304 is answered from the cache
+	w := ts.get("/repos/o/r", "Cache-Control", "no-cache")
+	expect(t, w, 200, "revalidated")
+	if w.Body.String() != miss.Body.String() { t.Fatalf("revalidated body %q, want %q", w.Body, miss.Body) }
+	if n := ts.fake.Requests("tok-a"); n != 2 { t.Fatalf("%d upstream requests, want 2", n) }
+	// so does Pragma from HTTP/1.0 clients
+	expect(t, ts.get("/repos/o/r", "Pragma", "no-cache"), 200, "revalidated")
+}
+
+func TestProxyNoStore(t *testing.T) {
+	ts := newTestServer(t)
+	expect(t, ts.get("/repos/o/r", "Cache-Control", "no-store"), 200, "miss")
+	// nothing was kept
+	expect(t, ts.get("/repos/o/r"), 200, "miss")
+	// a cached copy may still answer a no-store request
+	expect(t, ts.get("/repos/o/r", "Cache-Control", "no-store"), 200, "hit")
+	if n := ts.fake.Requests("tok-a"); n != 2 { t.Fatalf("%d upstream requests, want 2", n) }
+}
+
+func TestProxyMaxAge(t *testing.T) {
+	ts := newTestServer(t)
+	expect(t, ts.get("/repos/o/r"), 200, "miss")
+	expect(t, ts.get("/repos/o/r", "Cache-Control", "max-age=60"), 200, "hit")
+	// any age is too old for max-age=0, so the copy is revalidated
+	expect(t, ts.get("/repos/o/r", "Cache-Control", "max-age=0"), 200, "revalidated")
+	if n := ts.fake.Requests("tok-a"); n != 2 { t.Fatalf("%d upstream requests, want 2", n) }
+}
+
+func TestProxyOnlyIfCached(t *testing.T) {
+	ts := newTestServer(t)
+	if w := ts.get("/repos/o/r", "Cache-Control", "only-if-cached"); w.Code != http.StatusGatewayTimeout { t.Fatalf("got %d, want 504", w.Code) }
+	if n := ts.fake.Requests("tok-a"); n != 0 { t.Fatalf("%d upstream requests, want none", n) }
+	expect(t, ts.get("/repos/o/r"), 200, "miss")
+	expect(t, ts.get("/repos/o/r", "Cache-Control", "only-if-cached"), 200, "hit")
+	// too old for max-age and not allowed upstream
+	if w := ts.get("/repos/o/r", "Cache-Control", "only-if-cached, max-age=0"); w.Code != http.StatusGatewayTimeout { t.Fatalf("got %d, want 504", w.Code) }
+	if n := ts.fake.Requests("tok-a"); n != 1 { t.Fatalf("%d upstream requests, want 1", n) }
+}
+
+func TestProxyExhausted(t *testing.T) {
+	ts := newTestServer(t)
+	ts.fake.SetRemaining("tok-a", "core", 0)
+	w := ts.get("/repos/o/r")
+	if w.Code != http.StatusTooManyRequests { t.Fatalf("got %d, want 429: %s", w.Code, w.Body) }
+	if secs, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || secs <= 0 || secs > 3600 { t.Fatalf("Retry-After %q, want the token's reset", w.Header().Get("Retry-After")) }
+	if n := ts.fake.Requests("tok-a"); n != 1 { t.Fatalf("%d upstream requests, want 1", n) }
+	// the token stays benched, so the next request doesn't reach GitHub
+	if w := ts.get("/repos/o/r"); w.Code != http.StatusTooManyRequests { t.Fatalf("got %d, want 429", w.Code) }
+	if n := ts.fake.Requests("tok-a"); n != 1 { t.Fatalf("%d upstream requests, want 1", n) }
+}
+
+func TestCappedBuffer(t *testing.T) {
+	cases := []struct {
+		name string
+		writes []string
+		want string
+		over bool
+	}{
+		{"empty", nil, "", false},
+		{"under the limit", []string{"abc"}, "abc", false},
+		{"exactly the limit", []string{"abcd"}, "abcd", false},
+		{"exactly the limit in pieces", []string{"ab", "cd"}, "abcd", false},
+		{"one byte over", []string{"abcde"}, "", true},
+		{"one byte over in pieces", []string{"abcd", "e"}, "", true},
+		{"writes after going over", []string{"abcde", "", "f"}, "", true},
+	}
+	for _, c := range cases {
+		overs := 0
+		b := &cappedBuffer{max: 4, onOver: func() { overs++ }}
+		for _, w := range c.writes {
+			// writes always succeed so a tee'd copy to the client isn't cut short
+			if n, err := b.Write([]byte(w)); n != len(w) || err != nil { t.Fatalf("%s: Write = %d, %v", c.name, n, err) }
+		}
+		if string(b.b) != c.want || b.over != c.over { t.Errorf("%s: buffer %q over %v, want %q over %v", c.name, b.b, b.over, c.want, c.over) }
+		if want := map[bool]int{true: 1, false: 0}[c.over]; overs != want { t.Errorf("%s: onOver called %d times, want %d", c.name, overs, want) }
+	}
+}
+
+// countingReader is an endless reader that counts what was taken from it.
+type countingReader struct{ n int }
+
+func (r *countingReader) Read(p []byte) (int, error) { r.n += len(p); return len(p), nil }
+
+func TestCappedBufferFill(t *testing.T) {
+	for _, size := range []int{4, 5} {
+		b := &cappedBuffer{max: 4}
+		if err := b.fill(strings.NewReader(strings.Repeat("x", size))); err != nil { t.Fatal(err) }
+		if over := size > 4; b.over != over || (!over && len(b.b) != size) { t.Errorf("fill %d bytes: buffer %q over %v", size, b.b, b.over) }
+	}
+	// an endless body is only read one byte past the limit
+	src := &countingReader{}
+	b := &cappedBuffer{max: 1000}
+	if err := b.fill(src); err != nil { t.Fatal(err) }
+	if !b.over || src.n > 1001 { t.Fatalf("over %v after reading %d bytes", b.over, src.n) }
+}
+
+func TestCopyStreaming(t *testing.T) {
+	body := strings.Repeat("0123456789", 10000)
+	for _, max := range []int64{int64(len(body)), int64(len(body)) - 1} {
+		w := httptest.NewRecorder()
+		buf := &cappedBuffer{max: max}
+		if err := copyStreaming(w, strings.NewReader(body), buf); err != nil { t.Fatal(err) }
+		// the client always gets the whole body; the cache copy only when it fits
+		if w.Body.String() != body { t.Fatalf("max %d: client got %d bytes, want %d", max, w.Body.Len(), len(body)) }
+		if fits := max >= int64(len(body)); buf.over == fits || (fits && string(buf.b) != body) { t.Fatalf("max %d: buffer over %v with %d bytes", max, buf.over, len(buf.b)) }
+	}
+	if err := copyStreaming(httptest.NewRecorder(), strings.NewReader(body), nil); err != nil { t.Fatal(err) }
+}
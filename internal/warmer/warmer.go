@@ -0,0 +1,159 @@
+package warmer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"gh-proxy/internal/cache"
+	"gh-proxy/internal/config"
+	gh "gh-proxy/internal/github"
+)
+
+// Warmer refreshes admin-registered URL lists (warm_jobs) into the cache ahead
+// of demand. It runs at low priority: one request at a time, spaced out, and
+// only while the donated-token pool has plenty of budget left.
+type Warmer struct {
+	pool *pgxpool.Pool
+	cache *cache.Cache
+	gh *gh.Client
+	apiBase string // GITHUB_API_URL
+	minBudgetPct int64
+	delay time.Duration
+}
+
+func New(pool *pgxpool.Pool, c *cache.Cache, g *gh.Client, cfg config.Config) *Warmer {
+	return &Warmer{
+		pool: pool,
+		cache: c,
+		gh: g,
+		apiBase: cfg.GithubAPIURL,
+		minBudgetPct: cfg.WarmMinBudgetPct,
+		delay: time.Duration(cfg.WarmRequestDelayMS) * time.Millisecond,
+	}
+}
+
+var placeholder = regexp.MustCompile(`\{[A-Za-z0-9_]+\}`)
+
+// Expand turns a job's newline-separated paths into concrete API paths. A path
+// containing a {placeholder} is repeated once per line of values.
+func Expand(paths, values string) []string {
+	var vals []string
+	for _, v := range strings.Split(values, "\n") {
+		if v = strings.TrimSpace(v); v != "" { vals = append(vals, v) }
+	}
+	var out []string
+	for _, p := range strings.Split(paths, "\n") {
+		p = strings.TrimSpace(p)
+		if p == "" { continue }
+		if !strings.HasPrefix(p, "/") { p = "/" + p }
+		if !placeholder.MatchString(p) { out = append(out, p); continue }
+		for _, v := range vals {
+			out = append(out, placeholder.ReplaceAllLiteralString(p, url.PathEscape(v)))
+		}
+	}
+	return out
+}
+
+// Run checks for due jobs forever.
+func (w *Warmer) Run() {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for range t.C {
+		w.runDue(context.Background())
+	}
+}
+
+// warmLockKey is the Postgres advisory lock held by the replica that is warming.
+const warmLockKey int64 = 0x67682d7761726d // "gh-warm"
+
+type job struct {
+	id int64
+	name, paths, values string
+}
+
+func (w *Warmer) runDue(ctx context.Context) {
+	// one replica warms at a time: the pacing and budget checks are meant for
+	// the whole deployment, and two replicas would fetch every job twice. The
+	// lock goes with the connection if the process dies.
+	conn, err := w.pool.Acquire(ctx)
+	if err != nil { log.Printf("warmer: %v", err); return }
+	defer conn.Release()
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, warmLockKey).Scan(&locked); err != nil || !locked { return }
+	defer func() { _, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, warmLockKey) }()
+
+	rows, err := conn.Query(ctx, `SELECT id, name, paths, vals FROM warm_jobs WHERE enabled AND (last_run_at IS NULL OR last_run_at + make_interval(secs => interval_seconds) <= now()) ORDER BY last_run_at NULLS FIRST`)
+	if err != nil { log.Printf("warmer: list jobs: %v", err); return }
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.name, &j.paths, &j.values); err != nil { rows.Close(); log.Printf("warmer: %v", err); return }
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	for _, j := range jobs {
+		status, done := w.runJob(ctx, j)
+		if done {
+			_, _ = w.pool.Exec(ctx, `UPDATE warm_jobs SET last_run_at=now(), last_status=$2 WHERE id=$1`, j.id, status)
+		} else {
+			// leave last_run_at alone so the job is retried on the next tick
+			_, _ = w.pool.Exec(ctx, `UPDATE warm_jobs SET last_status=$2 WHERE id=$1`, j.id, status)
+			return
+		}
+	}
+}
+
+// runJob warms every URL of a job. done is false when it stopped early because the token budget got tight.
+func (w *Warmer) runJob(ctx context.Context, j job) (status string, done bool) {
+	paths := Expand(j.paths, j.values)
+	var fetched, revalidated, failed int
+	for i, p := range paths {
+		target := w.apiBase + p
+		cat := gh.CategoryFor(target)
+		remaining, limit, err := w.gh.Budget(ctx, cat)
+		if err != nil || limit == 0 || remaining*100 < w.minBudgetPct*limit {
+			log.Printf("warmer: %s paused at %d/%d: %s budget %d/%d", j.name, i, len(paths), cat, remaining, limit)
+			return fmt.Sprintf("paused at %d/%d: %s budget low", i, len(paths), cat), false
+		}
+		switch w.warm(ctx, target, cat, p) {
+		case http.StatusOK:
+			fetched++
+		case http.StatusNotModified:
+			revalidated++
+		default:
+			failed++
+		}
+		time.Sleep(w.delay)
+	}
+	log.Printf("warmer: %s done: %d fetched, %d revalidated, %d failed", j.name, fetched, revalidated, failed)
+	return fmt.Sprintf("ok: %d fetched, %d revalidated, %d failed", fetched, revalidated, failed), true
+}
+
+// warm fetches one URL (conditionally, if a copy is cached) and stores it the way serveProxy would.
+func (w *Warmer) warm(ctx context.Context, target, category, path string) int {
+	key := cache.NewKey(http.MethodGet, target, nil, http.Header{})
+	pol := w.cache.PolicyFor(category, http.MethodGet, path)
+	if !pol.Store { return 0 }
+	var cond http.Header
+	prev, _ := w.cache.Lookup(ctx, key)
+	if prev != nil { cond = prev.ConditionalHeaders() }
+	status, hdr, body, _, err := w.gh.DoWithHeaders(ctx, http.MethodGet, target, nil, cond)
+	if err != nil { log.Printf("warmer: %s: %v", path, err); return status }
+	switch {
+	case status == http.StatusNotModified && prev != nil && cond != nil:
+		if err := w.cache.Refresh(ctx, prev, pol.TTL); err != nil { log.Printf("warmer: refresh %s: %v", path, err) }
+	case status == http.StatusOK && cache.UpstreamStorable(hdr):
+		hdrJSON, _ := json.Marshal(hdr)
+		if err := w.cache.Put(ctx, key, nil, status, hdrJSON, body, pol.TTL); err != nil { log.Printf("warmer: store %s: %v", path, err) }
+	}
+	return status
+}